@@ -3,6 +3,7 @@ module github.com/bwplotka/mdox
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/Kunde21/markdownfmt/v2 v2.1.1-0.20210622145915-e6bf3dcd02de
 	github.com/antchfx/xmlquery v1.3.4 // indirect
 	github.com/charmbracelet/glamour v0.3.0
@@ -25,7 +26,7 @@ require (
 	github.com/yuin/goldmark v1.3.5
 	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
 	golang.org/x/net v0.0.0-20210331212208-0fccb6fa2b5c
-	golang.org/x/tools v0.0.0-20201020161133-226fd2f889ca // indirect
+	golang.org/x/tools v0.0.0-20201020161133-226fd2f889ca
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
@@ -6,20 +6,26 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	pathpkg "path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 
 	"github.com/bwplotka/mdox/pkg/clilog"
 	"github.com/bwplotka/mdox/pkg/extkingpin"
 	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/bwplotka/mdox/pkg/mdformatter/codeblock"
 	"github.com/bwplotka/mdox/pkg/mdformatter/linktransformer"
 	"github.com/bwplotka/mdox/pkg/mdformatter/mdgen"
+	"github.com/bwplotka/mdox/pkg/mdformatter/slug"
 	"github.com/bwplotka/mdox/pkg/transform"
 	"github.com/bwplotka/mdox/pkg/version"
 	"github.com/charmbracelet/glamour"
+	"github.com/efficientgo/tools/core/pkg/merrors"
 	extflag "github.com/efficientgo/tools/extkingpin"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -34,6 +40,18 @@ const (
 	logFormatCLILog = "clilog"
 )
 
+const (
+	graphFormatDOT  = "dot"
+	graphFormatJSON = "json"
+	graphFormatText = "text"
+)
+
+const (
+	verifyGoNone   = "none"
+	verifyGoMarked = "marked"
+	verifyGoAll    = "all"
+)
+
 func setupLogger(logLevel, logFormat string) log.Logger {
 	var lvl level.Option
 	switch logLevel {
@@ -70,6 +88,9 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	registerFmt(ctx, app)
 	registerTransform(ctx, app)
+	registerOrphans(ctx, app)
+	registerGraph(ctx, app)
+	registerMv(ctx, app)
 
 	cmd, runner := app.Parse()
 	logger := setupLogger(*logLevel, *logFormat)
@@ -123,17 +144,59 @@ func registerFmt(_ context.Context, app *extkingpin.App) {
 	disableGenCodeBlocksDirectives := cmd.Flag("code.disable-directives", `If false, fmt will parse custom fenced code directives prefixed with 'mdox-gen' to autogenerate code snippets. For example:
 	`+"```"+`<lang> mdox-exec="<executable + arguments>"
 This directive runs executable with arguments and put its stderr and stdout output inside code block content, replacing existing one.`).Bool()
+	formatCodeBlocks := cmd.Flag("code.format", `If true, fmt will format content of code blocks by language from info string: Go using gofmt, YAML and JSON by re-indenting. `+
+		`Code blocks that can't be formatted are left as they are with a warning. To skip formatting of a code block, add 'mdox-fmt=off' attribute, for example:
+	`+"```"+`yaml mdox-fmt=off`).Bool()
+	verifyGoCodeBlocks := cmd.Flag("code.verify-go", `If "all" or "marked", fmt will type-check Go code blocks against the Go module markdown file belongs to and fail if code does not compile. `+
+		`Fragments without package clause are wrapped into a package or function. If "marked", only code blocks with 'mdox-verify' attribute are checked, for example:
+	`+"```"+`go mdox-verify`).Default(verifyGoNone).Enum(verifyGoNone, verifyGoMarked, verifyGoAll)
+	validateCodeBlocks := cmd.Flag("code.validate", `If true, fmt will validate syntax of YAML, JSON, TOML and Go code blocks and fail on invalid ones. `+
+		`YAML and JSON code blocks with 'mdox-type' attribute are additionally validated against mdox configuration types (transform.Config, linktransformer.Config, linktransformer.RewriteConfig). `+
+		`To skip validation of a code block, add 'mdox-validate=off' attribute, for example:
+	`+"```"+`yaml mdox-validate=off`).Bool()
 	anchorDir := cmd.Flag("anchor-dir", "Anchor directory for all transformers. PWD is used if flag is not specified.").ExistingDir()
 	linksLocalizeForAddress := cmd.Flag("links.localize.address-regex", "If specified, all HTTP(s) links that target a domain and path matching given regexp will be transformed to relative to anchor dir path (if exists)."+
 		"Absolute path links will be converted to relative links to anchor dir as well.").Regexp()
+	linksGlobalizeBaseURL := cmd.Flag("links.globalize.base-url", "If specified, all relative and absolute path links to existing local files will be transformed to absolute URLs by joining given base URL with path relative to anchor dir, "+
+		"e.g 'https://github.com/org/repo/blob/{ref}/'. Useful for markdown published where relative links do not work. Inverse of --links.localize.address-regex, so they can't be used together.").String()
+	linksGlobalizeRawBaseURL := cmd.Flag("links.globalize.raw-base-url", "Base URL for links to files other than markdown ones (e.g images), so they point to content of files and not to pages rendering them, "+
+		"e.g 'https://github.com/org/repo/raw/{ref}/'. Defaults to --links.globalize.base-url.").String()
+	linksGlobalizeRef := cmd.Flag("links.globalize.ref", "Git ref replacing '{ref}' placeholder in --links.globalize.base-url and --links.globalize.raw-base-url. "+
+		"Special values 'HEAD' and 'tag' are resolved in local git repository of anchor dir to commit SHA of HEAD and to the latest tag reachable from HEAD.").Default(linktransformer.GitRefHead).String()
+	linksRewriteConfig := extflag.RegisterPathOrContent(cmd, "links.rewrite.config", "YAML file with regex rules rewriting link destinations, applied before other link transformations, with spec defined in github.com/bwplotka/mdox/pkg/linktransformer.RewriteConfig", extflag.WithEnvSubstitution())
+	linksHeadingIDStyle := cmd.Flag("links.heading-id-style", "Algorithm of generating heading IDs that local links with fragment (e.g 'doc.md#heading') are checked against during localization and validation. "+
+		"Used for link checking only, mdox does not add heading IDs to formatted files. Choose the one matching where markdown is published: 'github' (also default for Hugo with Goldmark), 'hugo' (Hugo with Blackfriday) or 'docusaurus'. "+
+		"Custom '{#id}' IDs are accepted in all styles. Duplicated headings get '-<number>' suffix in all styles.").Default(string(slug.GitHub)).Enum(string(slug.GitHub), string(slug.Hugo), string(slug.Docusaurus))
 	// TODO(bwplotka): Add cache in file?
 	linksValidateEnabled := cmd.Flag("links.validate", "If true, all links will be validated").Short('l').Bool()
+	linksValidateFixRedirects := cmd.Flag("links.validate.fix-redirects", "If true, remote links that are permanently redirected (301 or 308) will be rewritten to the URL they are redirected to. "+
+		"Otherwise such links are reported as warnings. Links are validated in all files first, so files are parsed twice.").Bool()
+	linksValidateFailOn := cmd.Flag("links.validate.fail-on", "The least severity of invalid links that fails validation. Invalid links with lower severity, configured per validator in links validate config, are only logged. "+
+		"Invalid local links are always of 'error' severity.").Default(string(linktransformer.SeverityError)).Enum(string(linktransformer.SeverityError), string(linktransformer.SeverityWarn), string(linktransformer.SeverityInfo))
+	linksValidateFmtOff := cmd.Flag("links.validate.fmt-off", "If true, links in regions between '<!-- mdox-fmt off -->' and '<!-- mdox-fmt on -->' markers, which are not formatted, are validated too.").Bool()
 	linksValidateConfig := extflag.RegisterPathOrContent(cmd, "links.validate.config", "YAML file for skipping link check, with spec defined in github.com/bwplotka/mdox/pkg/linktransformer.ValidatorConfig", extflag.WithEnvSubstitution())
 
 	cmd.Run(func(ctx context.Context, logger log.Logger) (err error) {
 		var opts []mdformatter.Option
+		var codeTr []mdformatter.CodeBlockTransformer
 		if !*disableGenCodeBlocksDirectives {
-			opts = append(opts, mdformatter.WithCodeBlockTransformer(mdgen.NewCodeBlockTransformer()))
+			codeTr = append(codeTr, mdgen.NewCodeBlockTransformer())
+		}
+		if *formatCodeBlocks {
+			codeTr = append(codeTr, codeblock.NewFormatter(logger))
+		}
+		if *validateCodeBlocks {
+			codeTr = append(codeTr, codeblock.NewValidator(
+				codeblock.WithType("transform.Config", transform.Config{}),
+				codeblock.WithType("linktransformer.Config", linktransformer.Config{}),
+				codeblock.WithType("linktransformer.RewriteConfig", linktransformer.RewriteConfig{}),
+			))
+		}
+		if *verifyGoCodeBlocks != verifyGoNone {
+			codeTr = append(codeTr, codeblock.NewGoVerifier(*verifyGoCodeBlocks == verifyGoMarked))
+		}
+		if len(codeTr) > 0 {
+			opts = append(opts, mdformatter.WithCodeBlockTransformer(codeblock.NewChain(codeTr...)))
 		}
 		if len(*files) == 0 {
 			return errors.New("no files to format")
@@ -151,24 +214,68 @@ This directive runs executable with arguments and put its stderr and stdout outp
 			return err
 		}
 
+		linkOpts := []linktransformer.Option{linktransformer.WithHeadingIDStyle(slug.Style(*linksHeadingIDStyle))}
 		var linkTr []mdformatter.LinkTransformer
+		rewriteConfigContent, err := linksRewriteConfig.Content()
+		if err != nil {
+			return err
+		}
+		if len(rewriteConfigContent) > 0 {
+			r, err := linktransformer.NewRewriter(logger, anchorDir, rewriteConfigContent)
+			if err != nil {
+				return err
+			}
+			linkTr = append(linkTr, r)
+		}
 		if *linksValidateEnabled {
 			validateConfigContent, err := linksValidateConfig.Content()
 			if err != nil {
 				return err
 			}
-			v, err := linktransformer.NewValidator(ctx, logger, validateConfigContent, anchorDir)
+			if *linksValidateFixRedirects {
+				linkOpts = append(linkOpts, linktransformer.WithFixPermanentRedirects())
+			}
+			v, err := linktransformer.NewValidator(ctx, logger, validateConfigContent, anchorDir, append(linkOpts, linktransformer.WithFailOn(linktransformer.Severity(*linksValidateFailOn)))...)
 			if err != nil {
 				return err
 			}
 			linkTr = append(linkTr, v)
+			if *linksValidateFmtOff {
+				opts = append(opts, mdformatter.WithFormatOffRegionLinks())
+			}
 		}
 		if *linksLocalizeForAddress != nil {
-			linkTr = append(linkTr, linktransformer.NewLocalizer(logger, *linksLocalizeForAddress, anchorDir))
+			if *linksGlobalizeBaseURL != "" {
+				return errors.New("--links.localize.address-regex and --links.globalize.base-url can't be used together")
+			}
+			linkTr = append(linkTr, linktransformer.NewLocalizer(logger, *linksLocalizeForAddress, anchorDir, linkOpts...))
+		}
+		if *linksGlobalizeBaseURL != "" {
+			baseURL, rawBaseURL := *linksGlobalizeBaseURL, *linksGlobalizeRawBaseURL
+			if strings.Contains(baseURL+rawBaseURL, "{ref}") {
+				ref, err := linktransformer.ResolveGitRef(anchorDir, *linksGlobalizeRef)
+				if err != nil {
+					return err
+				}
+				baseURL = strings.ReplaceAll(baseURL, "{ref}", ref)
+				rawBaseURL = strings.ReplaceAll(rawBaseURL, "{ref}", ref)
+			}
+			linkTr = append(linkTr, linktransformer.NewGlobalizer(logger, baseURL, anchorDir, append(linkOpts, linktransformer.WithRawBaseURL(rawBaseURL))...))
 		}
 
 		if len(linkTr) > 0 {
-			opts = append(opts, mdformatter.WithLinkTransformer(linktransformer.NewChain(linkTr...)))
+			linkOpt := mdformatter.WithLinkTransformer(linktransformer.NewChain(linkTr...))
+			opts = append(opts, linkOpt)
+			if *linksValidateEnabled && *linksValidateFixRedirects {
+				// Validate links of all files first, so permanently redirected ones are known when files are formatted.
+				validateOpts := []mdformatter.Option{linkOpt}
+				if *linksValidateFmtOff {
+					validateOpts = append(validateOpts, mdformatter.WithFormatOffRegionLinks())
+				}
+				if _, err := mdformatter.IsFormatted(ctx, logger, *files, validateOpts...); err != nil {
+					return err
+				}
+			}
 		}
 
 		if *checkOnly {
@@ -231,3 +338,303 @@ func registerTransform(_ context.Context, app *extkingpin.App) {
 		return transform.Dir(ctx, logger, validateConfig)
 	})
 }
+
+func registerOrphans(_ context.Context, app *extkingpin.App) {
+	cmd := app.Command("orphans", "Reports markdown files and images in anchor dir that are not linked from any other markdown file, e.g left after reorganization of documentation. Example: mdox orphans --entrypoint=README.md --entrypoint='docs/index.md'")
+	anchorDir := cmd.Flag("anchor-dir", "Directory with markdown files and images to check. Local links are resolved against it. PWD is used if flag is not specified.").ExistingDir()
+	entrypoints := cmd.Flag("entrypoint", "Glob of files that are never reported, as they are entry points of documentation. Glob is matched against path relative to anchor dir and, if it does not contain '/', against file name. "+
+		"Can be repeated.").Default("README.md").Strings()
+	excludes := cmd.Flag("exclude", "Glob of files and directories to skip, matched the same way as entrypoint, e.g 'node_modules'. Hidden directories are always skipped. Can be repeated.").Strings()
+	cmd.Run(func(ctx context.Context, logger log.Logger) error {
+		dir, err := validateAnchorDir(*anchorDir, nil)
+		if err != nil {
+			return err
+		}
+
+		docs, files, err := findDocs(dir, *excludes)
+		if err != nil {
+			return err
+		}
+
+		g := linktransformer.NewGraph(dir)
+		if _, err := mdformatter.IsFormatted(ctx, logger, docs, mdformatter.WithLinkTransformer(g)); err != nil {
+			return err
+		}
+		orphans := g.Orphans(files, func(file string) bool { return matchesGlobs(dir, file, *entrypoints) })
+		if len(orphans) == 0 {
+			return nil
+		}
+		for i := range orphans {
+			if rel, err := filepath.Rel(dir, orphans[i]); err == nil {
+				orphans[i] = rel
+			}
+		}
+		return errors.Errorf("found %v files not linked from any markdown file:\n%v", len(orphans), strings.Join(orphans, "\n"))
+	})
+}
+
+func registerGraph(_ context.Context, app *extkingpin.App) {
+	cmd := app.Command("graph", "Prints graph of links between markdown files in anchor dir and files or URLs they link to, with positions of links. Example: mdox graph --format=dot | dot -Tsvg > docs.svg")
+	anchorDir := cmd.Flag("anchor-dir", "Directory with markdown files to print graph of. Local links are resolved against it. PWD is used if flag is not specified.").ExistingDir()
+	excludes := cmd.Flag("exclude", "Glob of files and directories to skip, matched against path relative to anchor dir and, if it does not contain '/', against file name, e.g 'node_modules'. "+
+		"Hidden directories are always skipped. Can be repeated.").Strings()
+	format := cmd.Flag("format", "Output format: 'dot' (Graphviz), 'json' or 'text' (one link per line with its position).").Default(graphFormatDOT).Enum(graphFormatDOT, graphFormatJSON, graphFormatText)
+	remote := cmd.Flag("remote", "If false, remote links are not included.").Default("true").Bool()
+	backlinks := cmd.Flag("backlinks", "If specified, only links to given file or directory from other files are included, answering which documents link to it. Links to directory are included for its index file too, e.g 'README.md'.").String()
+	cmd.Run(func(ctx context.Context, logger log.Logger) error {
+		dir, err := validateAnchorDir(*anchorDir, nil)
+		if err != nil {
+			return err
+		}
+		docs, _, err := findDocs(dir, *excludes)
+		if err != nil {
+			return err
+		}
+
+		g := linktransformer.NewGraph(dir)
+		if _, err := mdformatter.IsFormatted(ctx, logger, docs, mdformatter.WithLinkTransformer(g)); err != nil {
+			return err
+		}
+		links := g.Edges()
+		if *backlinks != "" {
+			target, err := filepath.Abs(*backlinks)
+			if err != nil {
+				return err
+			}
+			links = g.Backlinks(target)
+		}
+		if !*remote {
+			local := links[:0]
+			for _, l := range links {
+				if l.To != "" {
+					local = append(local, l)
+				}
+			}
+			links = local
+		}
+
+		switch *format {
+		case graphFormatJSON:
+			return g.WriteJSON(os.Stdout, links)
+		case graphFormatText:
+			return g.WriteText(os.Stdout, links)
+		default:
+			return g.WriteDOT(os.Stdout, links)
+		}
+	})
+}
+
+func registerMv(_ context.Context, app *extkingpin.App) {
+	cmd := app.Command("mv", "Moves or renames markdown file or directory and rewrites relative links in moved files and links to moved files in all markdown files in anchor dir. "+
+		"Only link destinations are changed, files are not formatted. Example: mdox mv docs/old.md docs/guides/new.md")
+	src := cmd.Arg("source", "Markdown file or directory to move.").Required().ExistingFileOrDir()
+	dst := cmd.Arg("destination", "New path of moved file or directory. If it's existing directory, source is moved into it.").Required().String()
+	assets := cmd.Flag("assets", "Directory (e.g with images) that is moved together with source markdown file, keeping its relative path to it. Can be repeated.").ExistingDirs()
+	anchorDir := cmd.Flag("anchor-dir", "Directory with markdown files, which links are rewritten. Local links are resolved against it. PWD is used if flag is not specified.").ExistingDir()
+	excludes := cmd.Flag("exclude", "Glob of files and directories to skip, matched against path relative to anchor dir and, if it does not contain '/', against file name, e.g 'node_modules'. "+
+		"Hidden directories are always skipped. Can be repeated.").Strings()
+	cmd.Run(func(ctx context.Context, logger log.Logger) error {
+		from, err := filepath.Abs(*src)
+		if err != nil {
+			return err
+		}
+		to, err := filepath.Abs(*dst)
+		if err != nil {
+			return err
+		}
+		if st, err := os.Stat(to); err == nil && st.IsDir() {
+			to = filepath.Join(to, filepath.Base(from))
+		}
+		dir, err := validateAnchorDir(*anchorDir, []string{from, to})
+		if err != nil {
+			return err
+		}
+
+		moves := map[string]string{from: to}
+		for _, a := range *assets {
+			a, err := filepath.Abs(a)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(filepath.Dir(from), a)
+			if err != nil {
+				return err
+			}
+			moves[a] = filepath.Join(filepath.Dir(to), rel)
+		}
+		for f, t := range moves {
+			if _, err := os.Stat(t); err == nil {
+				return errors.Errorf("can't move %v, %v already exists", f, t)
+			}
+		}
+
+		docs, _, err := findDocs(dir, *excludes)
+		if err != nil {
+			return err
+		}
+		g := linktransformer.NewGraph(dir)
+		if _, err := mdformatter.IsFormatted(ctx, logger, docs, mdformatter.WithLinkTransformer(g)); err != nil {
+			return err
+		}
+
+		// Rewrite links in moved files and in files linking to moved ones.
+		var moved []string
+		for f := range moves {
+			moved = append(moved, f)
+		}
+		affected := map[string]struct{}{}
+		for _, d := range docs {
+			for _, m := range moved {
+				if d == m || strings.HasPrefix(d, m+string(filepath.Separator)) {
+					affected[d] = struct{}{}
+				}
+			}
+		}
+		for _, f := range g.Referrers(moved...) {
+			affected[f] = struct{}{}
+		}
+		toRewrite := make([]string, 0, len(affected))
+		for f := range affected {
+			toRewrite = append(toRewrite, f)
+		}
+		sort.Strings(toRewrite)
+		contents, err := linktransformer.MoveLinks(ctx, dir, moves, toRewrite)
+		if err != nil {
+			return err
+		}
+
+		// Move files before rewriting links, so nothing is changed if move fails.
+		if err := moveFiles(logger, moves); err != nil {
+			return err
+		}
+		if err := writeFiles(contents); err != nil {
+			errs := merrors.New(err)
+			from := make([]string, 0, len(moves))
+			for f := range moves {
+				from = append(from, f)
+			}
+			sort.Strings(from)
+			errs.Add(moveBack(moves, from))
+			return errs.Err()
+		}
+		level.Info(logger).Log("msg", "rewrote links", "files", len(contents))
+		return nil
+	})
+}
+
+// writeFiles writes given contents to files, keeping their modes. If any write fails, already written files are
+// restored.
+func writeFiles(contents map[string][]byte) error {
+	files := make([]string, 0, len(contents))
+	for f := range contents {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	written := map[string][]byte{}
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err == nil {
+			var orig []byte
+			if orig, err = ioutil.ReadFile(f); err == nil {
+				written[f] = orig
+				err = ioutil.WriteFile(f, contents[f], st.Mode())
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		errs := merrors.New(errors.Wrapf(err, "rewrite links in %v", f))
+		for w, orig := range written {
+			errs.Add(errors.Wrapf(ioutil.WriteFile(w, orig, os.ModePerm), "restore %v", w))
+		}
+		return errs.Err()
+	}
+	return nil
+}
+
+// moveFiles moves files and directories from keys to values of given map. If any move fails, already moved ones are
+// moved back.
+func moveFiles(logger log.Logger, moves map[string]string) error {
+	from := make([]string, 0, len(moves))
+	for f := range moves {
+		from = append(from, f)
+	}
+	sort.Strings(from)
+
+	for i, f := range from {
+		err := os.MkdirAll(filepath.Dir(moves[f]), os.ModePerm)
+		if err == nil {
+			err = os.Rename(f, moves[f])
+		}
+		if err == nil {
+			level.Info(logger).Log("msg", "moved", "from", f, "to", moves[f])
+			continue
+		}
+
+		errs := merrors.New(errors.Wrapf(err, "move %v to %v", f, moves[f]))
+		errs.Add(moveBack(moves, from[:i]))
+		return errs.Err()
+	}
+	return nil
+}
+
+// moveBack moves given moved files and directories back, in reverse order.
+func moveBack(moves map[string]string, from []string) error {
+	errs := merrors.New()
+	for j := len(from) - 1; j >= 0; j-- {
+		errs.Add(errors.Wrapf(os.Rename(moves[from[j]], from[j]), "move back %v to %v", moves[from[j]], from[j]))
+	}
+	return errs.Err()
+}
+
+// findDocs returns markdown files (docs) and markdown and image files (files) from given dir, except excluded ones.
+func findDocs(dir string, excludes []string) (docs []string, files []string, _ error) {
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if matchesGlobs(dir, path, excludes) || (info.IsDir() && strings.HasPrefix(info.Name(), ".")) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if linktransformer.IsMarkdownFile(path) {
+			docs = append(docs, path)
+			files = append(files, path)
+		} else if linktransformer.IsImageFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, errors.Wrapf(err, "walk %v", dir)
+	}
+	return docs, files, nil
+}
+
+// matchesGlobs returns true if path relative to dir matches any of given globs. Globs without '/' are also
+// matched against file name.
+func matchesGlobs(dir string, path string, globs []string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, g := range globs {
+		if ok, _ := pathpkg.Match(g, rel); ok {
+			return true
+		}
+		if ok, _ := pathpkg.Match(g, filepath.Base(path)); ok && !strings.Contains(g, "/") {
+			return true
+		}
+	}
+	return false
+}
@@ -4,10 +4,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
 
+	"github.com/bwplotka/mdox/pkg/extkingpin"
 	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/kit/log"
+	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func TestValidateAnchorDir(t *testing.T) {
@@ -35,3 +45,111 @@ func TestValidateAnchorDir(t *testing.T) {
 	testutil.Ok(t, err)
 	testutil.Equals(t, "/root", anchorDir)
 }
+
+func TestFmt_LinksValidateConfigEnvSubstitution(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-fmt")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	t.Cleanup(srv.Close)
+
+	testutil.Ok(t, os.Setenv("MDOX_TEST_TOKEN", "secret"))
+	t.Cleanup(func() { testutil.Ok(t, os.Unsetenv("MDOX_TEST_TOKEN")) })
+
+	testFile := filepath.Join(tmpDir, "doc.md")
+	testutil.Ok(t, ioutil.WriteFile(testFile, []byte(fmt.Sprintf("[Private](%v/private)\n", srv.URL)), os.ModePerm))
+
+	args := os.Args
+	t.Cleanup(func() { os.Args = args })
+	os.Args = []string{"mdox", "fmt", "--check", "--anchor-dir", tmpDir, "--links.validate", "--links.validate.config", fmt.Sprintf(`version: 1
+validators:
+  - regex: '%v'
+    type: 'roundtrip'
+    headers:
+      Authorization: 'Bearer $(MDOX_TEST_TOKEN)'
+`, regexp.QuoteMeta(srv.URL)), testFile}
+
+	app := extkingpin.NewApp(kingpin.New("mdox", ""))
+	registerFmt(context.Background(), app)
+	_, runner := app.Parse()
+	testutil.Ok(t, runner(context.Background(), log.NewNopLogger()))
+	testutil.Equals(t, "Bearer secret", authorization)
+}
+
+func TestMv(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-mv")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, os.MkdirAll(filepath.Join(tmpDir, "docs", "img"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "README.md"), []byte("# Not   formatted\n\n* [Old](docs/old.md#header)\n* [Other](docs/other.md)\n"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "docs", "old.md"), []byte("# Header\n\n![Image](img/a.png)   [Readme](../README.md)\n"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "docs", "other.md"), []byte("![Image](img/a.png)   [Old](old.md)\n"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "docs", "img", "a.png"), []byte{}, os.ModePerm))
+
+	args := os.Args
+	t.Cleanup(func() { os.Args = args })
+	os.Args = []string{"mdox", "mv", "--anchor-dir", tmpDir, "--assets", filepath.Join(tmpDir, "docs", "img"),
+		filepath.Join(tmpDir, "docs", "old.md"), filepath.Join(tmpDir, "docs", "guides", "new.md")}
+
+	app := extkingpin.NewApp(kingpin.New("mdox", ""))
+	registerMv(context.Background(), app)
+	_, runner := app.Parse()
+	testutil.Ok(t, runner(context.Background(), log.NewNopLogger()))
+
+	for file, exp := range map[string]string{
+		"README.md":             "# Not   formatted\n\n* [Old](docs/guides/new.md#header)\n* [Other](docs/other.md)\n",
+		"docs/guides/new.md":    "# Header\n\n![Image](img/a.png)   [Readme](../../README.md)\n",
+		"docs/other.md":         "![Image](guides/img/a.png)   [Old](guides/new.md)\n",
+		"docs/guides/img/a.png": "",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(tmpDir, file))
+		testutil.Ok(t, err)
+		testutil.Equals(t, exp, string(b), file)
+	}
+	for _, file := range []string{"docs/old.md", "docs/img"} {
+		_, err := os.Stat(filepath.Join(tmpDir, file))
+		testutil.Assert(t, os.IsNotExist(err), file)
+	}
+}
+
+func TestMoveFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-move-files")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "a.md"), []byte{}, os.ModePerm))
+
+	// Move of not existing file fails, so already moved one is moved back.
+	err = moveFiles(log.NewNopLogger(), map[string]string{
+		filepath.Join(tmpDir, "a.md"): filepath.Join(tmpDir, "new", "a.md"),
+		filepath.Join(tmpDir, "b.md"): filepath.Join(tmpDir, "new", "b.md"),
+	})
+	testutil.NotOk(t, err)
+	_, err = os.Stat(filepath.Join(tmpDir, "a.md"))
+	testutil.Ok(t, err)
+	_, err = os.Stat(filepath.Join(tmpDir, "new", "a.md"))
+	testutil.Assert(t, os.IsNotExist(err))
+}
+
+func TestWriteFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-write-files")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "a.md"), []byte("old"), os.ModePerm))
+	testutil.Ok(t, os.MkdirAll(filepath.Join(tmpDir, "b.md"), os.ModePerm))
+
+	// Write of directory fails, so already written file is restored.
+	testutil.NotOk(t, writeFiles(map[string][]byte{
+		filepath.Join(tmpDir, "a.md"): []byte("new"),
+		filepath.Join(tmpDir, "b.md"): []byte("new"),
+	}))
+	b, err := ioutil.ReadFile(filepath.Join(tmpDir, "a.md"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "old", string(b))
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package codeblock
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/merrors"
+	"github.com/mattn/go-shellwords"
+	"github.com/pkg/errors"
+)
+
+type chain struct {
+	chain []mdformatter.CodeBlockTransformer
+}
+
+// NewChain returns mdformatter.CodeBlockTransformer that runs given transformers one after another, passing
+// code produced by one to the next one.
+func NewChain(c ...mdformatter.CodeBlockTransformer) mdformatter.CodeBlockTransformer {
+	return &chain{chain: c}
+}
+
+func (c *chain) TransformCodeBlock(ctx mdformatter.SourceContext, infoString []byte, code []byte) (_ []byte, err error) {
+	for _, t := range c.chain {
+		code, err = t.TransformCodeBlock(ctx, infoString, code)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return code, nil
+}
+
+func (c *chain) Close(ctx mdformatter.SourceContext) error {
+	errs := merrors.New()
+	for _, t := range c.chain {
+		errs.Add(t.Close(ctx))
+	}
+	return errs.Err()
+}
+
+// parseInfoString returns language and attributes from fenced code block info string e.g ```go mdox-verify.
+// Attributes without value are set to empty string.
+func parseInfoString(infoString []byte) (lang string, attrs map[string]string, err error) {
+	fields, err := shellwords.NewParser().Parse(string(infoString))
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "parsing info string %v", string(infoString))
+	}
+	attrs = map[string]string{}
+	if len(fields) == 0 {
+		return "", attrs, nil
+	}
+	for _, field := range fields[1:] {
+		if i := strings.Index(field, "="); i != -1 {
+			attrs[field[:i]] = field[i+1:]
+			continue
+		}
+		attrs[field] = ""
+	}
+	return strings.ToLower(fields[0]), attrs, nil
+}
+
+// relPath returns path relative to working directory, so it's easy to find for user.
+func relPath(path string) (string, error) {
+	base, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrap(err, "resolve working dir")
+	}
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return "", errors.Wrap(err, "find relative path")
+	}
+	return rel, nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package codeblock
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/format"
+	"go/scanner"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	infoStringKeyFmt = "mdox-fmt"
+	fmtOff           = "off"
+)
+
+// errLineRe matches line number in YAML and TOML parsing errors.
+var errLineRe = regexp.MustCompile(`line (\d+)`)
+
+type formatter struct {
+	logger log.Logger
+}
+
+// NewFormatter returns mdformatter.CodeBlockTransformer that formats code blocks content by language from info string:
+// * Go using gofmt.
+// * YAML and JSON by re-indenting it in canonical way (2 spaces).
+// Code blocks with `mdox-fmt=off` attribute are skipped, e.g ```yaml mdox-fmt=off. If code can't be formatted
+// (e.g it's invalid) it is left as it is, and warning with position is logged.
+func NewFormatter(logger log.Logger) mdformatter.CodeBlockTransformer {
+	return &formatter{logger: logger}
+}
+
+func (f *formatter) TransformCodeBlock(ctx mdformatter.SourceContext, infoString []byte, code []byte) ([]byte, error) {
+	lang, attrs, err := parseInfoString(infoString)
+	if err != nil {
+		return nil, err
+	}
+	if attrs[infoStringKeyFmt] == fmtOff || len(bytes.TrimSpace(code)) == 0 {
+		return code, nil
+	}
+
+	var (
+		formatted []byte
+		line      int
+	)
+	switch lang {
+	case "go":
+		formatted, line, err = formatGo(code)
+	case "yaml", "yml":
+		formatted, line, err = formatYAML(code)
+	case "json":
+		formatted, line, err = formatJSON(code)
+	default:
+		return code, nil
+	}
+	if err != nil {
+		fenceLine, _ := strconv.Atoi(ctx.LineNumbers)
+		path, perr := relPath(ctx.Filepath)
+		if perr != nil {
+			return nil, perr
+		}
+		level.Warn(f.logger).Log("msg", "can't format code block, leaving it as it is", "lang", lang, "pos", path+":"+strconv.Itoa(fenceLine+line), "err", err)
+		return code, nil
+	}
+	return formatted, nil
+}
+
+func (f *formatter) Close(mdformatter.SourceContext) error { return nil }
+
+// formatGo formats Go code using gofmt. It returns line of the first error within code, if any.
+func formatGo(code []byte) ([]byte, int, error) {
+	formatted, err := format.Source(code)
+	if err != nil {
+		if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+			return nil, list[0].Pos.Line, list[0]
+		}
+		return nil, 0, err
+	}
+	return formatted, 0, nil
+}
+
+// formatYAML re-indents YAML documents using 2 spaces. Comments are preserved. It returns line of the first error
+// within code, if any.
+func formatYAML(code []byte) ([]byte, int, error) {
+	b := bytes.Buffer{}
+	dec := yaml.NewDecoder(bytes.NewReader(code))
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	for {
+		n := yaml.Node{}
+		if err := dec.Decode(&n); err != nil {
+			if err == io.EOF {
+				break
+			}
+			line := 0
+			if m := errLineRe.FindStringSubmatch(err.Error()); m != nil {
+				line, _ = strconv.Atoi(m[1])
+			}
+			return nil, line, err
+		}
+		if err := enc.Encode(&n); err != nil {
+			return nil, 0, errors.Wrap(err, "encode")
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, 0, errors.Wrap(err, "close encoder")
+	}
+	return b.Bytes(), 0, nil
+}
+
+// formatJSON re-indents JSON using 2 spaces. It returns line of the first error within code, if any.
+func formatJSON(code []byte) ([]byte, int, error) {
+	b := bytes.Buffer{}
+	if err := json.Indent(&b, bytes.TrimSpace(code), "", "  "); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			trimmed := len(code) - len(bytes.TrimLeft(code, " \t\r\n"))
+			return nil, bytes.Count(code[:trimmed+int(serr.Offset)], []byte("\n")) + 1, err
+		}
+		return nil, 0, err
+	}
+	_, _ = b.WriteString("\n")
+	return b.Bytes(), 0, nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package codeblock
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/kit/log"
+)
+
+const (
+	testDocWithCodeToFormat = "# Code\n\n" +
+		"```go\nfunc   main( ) {\nfmt.Println(\"yolo\")\n}\n```\n\n" +
+		"```yaml\n# Comment.\na:\n    b:   1\n    c:\n    -   d # Inline comment.\n---\ne: f\n```\n\n" +
+		"```json\n{\"a\": [1,2],\n  \"b\": {\"c\": \"d\"}}\n```\n\n" +
+		"```yaml mdox-fmt=off\na:\n    b: 1\n```\n\n" +
+		"```json\n{\"a\":\n  [1,2}\n```\n"
+
+	testDocWithCodeFormatted = "# Code\n\n" +
+		"```go\nfunc main() {\n\tfmt.Println(\"yolo\")\n}\n```\n\n" +
+		"```yaml\n# Comment.\na:\n  b: 1\n  c:\n    - d # Inline comment.\n---\ne: f\n```\n\n" +
+		"```json\n{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": {\n    \"c\": \"d\"\n  }\n}\n```\n\n" +
+		"```yaml mdox-fmt=off\na:\n    b: 1\n```\n\n" +
+		"```json\n{\"a\":\n  [1,2}\n```\n"
+)
+
+func TestFormatter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-codeblock-fmt")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testFile := filepath.Join(tmpDir, "doc.md")
+	testutil.Ok(t, ioutil.WriteFile(testFile, []byte(testDocWithCodeToFormat), os.ModePerm))
+
+	wdir, err := os.Getwd()
+	testutil.Ok(t, err)
+	relPath, err := filepath.Rel(wdir, testFile)
+	testutil.Ok(t, err)
+
+	logs := bytes.Buffer{}
+	testutil.Ok(t, mdformatter.Format(context.TODO(), log.NewLogfmtLogger(&logs), []string{testFile}, mdformatter.WithCodeBlockTransformer(NewFormatter(log.NewLogfmtLogger(&logs)))))
+
+	b, err := ioutil.ReadFile(testFile)
+	testutil.Ok(t, err)
+	testutil.Equals(t, testDocWithCodeFormatted, string(b))
+	testutil.Equals(t, fmt.Sprintf("level=warn msg=\"can't format code block, leaving it as it is\" lang=json pos=%v:31 err=\"invalid character '}' after array value\"\n", relPath), logs.String())
+}
@@ -0,0 +1,218 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package codeblock
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/merrors"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+)
+
+const (
+	infoStringKeyVerify = "mdox-verify"
+
+	goSnippetFile = "snippet.go"
+	// goSnippetDir is a directory in Go module, where snippets are overlaid when loaded.
+	goSnippetDir = "_mdox_verify"
+)
+
+type goSnippet struct {
+	// fenceLine is a line number of opening fence in markdown file.
+	fenceLine int
+	code      []byte
+	// wrapperLines is a number of lines added on top of the snippet code to make it a valid Go file.
+	wrapperLines int
+}
+
+type goVerifier struct {
+	onlyMarked bool
+
+	snippets map[string][]goSnippet
+}
+
+// NewGoVerifier returns mdformatter.CodeBlockTransformer that type-checks Go code blocks against the Go module
+// the markdown file belongs to. Code blocks are not modified, all errors are reported on Close.
+// Fragments without package clause are wrapped into a package, and if needed, into a function, so snippets
+// like single statements can be verified too. If onlyMarked is true, only code blocks with `mdox-verify`
+// attribute are checked, e.g ```go mdox-verify.
+func NewGoVerifier(onlyMarked bool) mdformatter.CodeBlockTransformer {
+	return &goVerifier{onlyMarked: onlyMarked, snippets: map[string][]goSnippet{}}
+}
+
+func (v *goVerifier) TransformCodeBlock(ctx mdformatter.SourceContext, infoString []byte, code []byte) ([]byte, error) {
+	lang, attrs, err := parseInfoString(infoString)
+	if err != nil {
+		return nil, err
+	}
+	if lang != "go" {
+		return code, nil
+	}
+	if _, ok := attrs[infoStringKeyVerify]; v.onlyMarked && !ok {
+		return code, nil
+	}
+
+	fenceLine, err := strconv.Atoi(ctx.LineNumbers)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unexpected line number %q of code block", ctx.LineNumbers)
+	}
+	s := wrapGoSnippet(code)
+	s.fenceLine = fenceLine
+	v.snippets[ctx.Filepath] = append(v.snippets[ctx.Filepath], s)
+	return code, nil
+}
+
+// wrapGoSnippet returns snippet which code is a parsable Go file, if possible.
+func wrapGoSnippet(code []byte) goSnippet {
+	if _, err := parser.ParseFile(token.NewFileSet(), goSnippetFile, code, parser.PackageClauseOnly); err == nil {
+		return goSnippet{code: code}
+	}
+
+	declarations := append([]byte("package snippet\n\n"), code...)
+	if _, err := parser.ParseFile(token.NewFileSet(), goSnippetFile, declarations, parser.AllErrors); err == nil {
+		return goSnippet{code: declarations, wrapperLines: 2}
+	}
+
+	statements := append([]byte("package snippet\n\nfunc _() {\n"), code...)
+	statements = append(statements, []byte("\n}\n")...)
+	if _, err := parser.ParseFile(token.NewFileSet(), goSnippetFile, statements, parser.AllErrors); err == nil {
+		return goSnippet{code: statements, wrapperLines: 3}
+	}
+	// Not parsable in any way, report syntax errors as they are visible for declarations.
+	return goSnippet{code: declarations, wrapperLines: 2}
+}
+
+func (v *goVerifier) Close(ctx mdformatter.SourceContext) error {
+	snippets := v.snippets[ctx.Filepath]
+	delete(v.snippets, ctx.Filepath)
+	if len(snippets) == 0 {
+		return nil
+	}
+
+	path, err := relPath(ctx.Filepath)
+	if err != nil {
+		return err
+	}
+
+	modDir, err := findGoModuleDir(filepath.Dir(ctx.Filepath))
+	if err != nil {
+		return errors.Wrapf(err, "%v: verify Go code blocks", path)
+	}
+
+	// Snippets are placed within module, so they can import module packages. They are only overlaid, so nothing
+	// is written to the module.
+	overlay := map[string][]byte{}
+	patterns := make([]string, 0, len(snippets))
+	files := make([]string, 0, len(snippets))
+	for i, s := range snippets {
+		file := filepath.Join(modDir, goSnippetDir, strconv.Itoa(i), goSnippetFile)
+		overlay[file] = s.code
+		patterns = append(patterns, "file="+file)
+		files = append(files, file)
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedExportsFile,
+		Dir:     modDir,
+		Overlay: overlay,
+	}, patterns...)
+	if err != nil {
+		return errors.Wrapf(err, "%v: verify Go code blocks", path)
+	}
+	exports := map[string]string{}
+	packages.Visit(pkgs, nil, func(p *packages.Package) {
+		exports[p.PkgPath] = p.ExportFile
+	})
+
+	fset := token.NewFileSet()
+	// Type-check with importer of the Go toolchain producing export data, as it always understands its format.
+	imp := importer.ForCompiler(fset, "gc", func(importPath string) (io.ReadCloser, error) {
+		export, ok := exports[importPath]
+		if !ok || export == "" {
+			return nil, errors.Errorf("no export data for %v", importPath)
+		}
+		return os.Open(export)
+	})
+
+	var verifyErrs []goVerifyError
+	for i, s := range snippets {
+		s := s
+		f, err := parser.ParseFile(fset, files[i], s.code, parser.AllErrors)
+		if err != nil {
+			if list, ok := err.(scanner.ErrorList); ok {
+				for _, e := range list {
+					verifyErrs = append(verifyErrs, s.newError(e.Pos, e.Msg))
+				}
+				continue
+			}
+			return errors.Wrapf(err, "%v: parse Go code block", path)
+		}
+
+		conf := types.Config{
+			Importer: imp,
+			Error: func(err error) {
+				e, ok := err.(types.Error)
+				if !ok {
+					verifyErrs = append(verifyErrs, goVerifyError{msg: err.Error()})
+					return
+				}
+				if s.wrapperLines > 0 && e.Soft {
+					// Unused variables and imports are expected in fragments.
+					return
+				}
+				verifyErrs = append(verifyErrs, s.newError(e.Fset.Position(e.Pos), e.Msg))
+			},
+		}
+		_, _ = conf.Check(f.Name.Name, fset, []*ast.File{f}, nil)
+	}
+	sort.SliceStable(verifyErrs, func(i, j int) bool { return verifyErrs[i].line < verifyErrs[j].line })
+
+	merr := merrors.New()
+	for _, e := range verifyErrs {
+		if e.line == 0 {
+			merr.Add(errors.Errorf("%v: Go code block does not compile: %v", path, e.msg))
+			continue
+		}
+		merr.Add(errors.Errorf("%v:%v: Go code block does not compile: %v", path, e.line, e.msg))
+	}
+	return merr.Err()
+}
+
+type goVerifyError struct {
+	line int
+	msg  string
+}
+
+// newError returns error with position in snippet mapped to line in markdown file.
+func (s goSnippet) newError(pos token.Position, msg string) goVerifyError {
+	line := pos.Line - s.wrapperLines
+	if line < 1 {
+		line = 1
+	}
+	return goVerifyError{line: s.fenceLine + line, msg: msg}
+}
+
+// findGoModuleDir returns the closest directory with go.mod file, starting from given directory.
+func findGoModuleDir(dir string) (string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d, nil
+		}
+		if filepath.Dir(d) == d {
+			return "", errors.Errorf("no go.mod found in %v or any parent directory", dir)
+		}
+	}
+}
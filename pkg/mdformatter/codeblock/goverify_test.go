@@ -0,0 +1,61 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package codeblock
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/kit/log"
+)
+
+const testDocWithGoCode = "# Go\n\n" +
+	"```go\npackage main\n\nimport \"example.com/snippets/lib\"\n\nfunc main() { _ = lib.Add(1, 2) }\n```\n\n" +
+	"```go\nx := 1 + 2\n```\n\n" +
+	"```go mdox-verify\nfunc yolo() string {\n\treturn 1\n}\n```\n\n" +
+	"```go\npackage main\n\nimport \"example.com/snippets/lib\"\n\nfunc main() { _ = lib.Sub(1, 2) }\n```\n"
+
+func TestGoVerifier(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-goverify")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, os.MkdirAll(filepath.Join(tmpDir, "lib"), os.ModePerm))
+	testutil.Ok(t, os.MkdirAll(filepath.Join(tmpDir, "docs"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module example.com/snippets\n\ngo 1.15\n"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "lib", "lib.go"), []byte("package lib\n\nfunc Add(a, b int) int { return a + b }\n"), os.ModePerm))
+	testFile := filepath.Join(tmpDir, "docs", "doc.md")
+	testutil.Ok(t, ioutil.WriteFile(testFile, []byte(testDocWithGoCode), os.ModePerm))
+
+	wdir, err := os.Getwd()
+	testutil.Ok(t, err)
+	relPath, err := filepath.Rel(wdir, testFile)
+	testutil.Ok(t, err)
+
+	logger := log.NewLogfmtLogger(os.Stderr)
+	t.Run("all code blocks", func(t *testing.T) {
+		_, err := mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithCodeBlockTransformer(NewGoVerifier(false)))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: 2 errors: "+
+			"%v:17: Go code block does not compile: cannot use 1 (untyped int constant) as string value in return statement; "+
+			"%v:26: Go code block does not compile: undefined: lib.Sub", testFile, relPath, relPath), err.Error())
+	})
+	t.Run("only marked code blocks", func(t *testing.T) {
+		_, err := mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithCodeBlockTransformer(NewGoVerifier(true)))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: %v:17: Go code block does not compile: "+
+			"cannot use 1 (untyped int constant) as string value in return statement", testFile, relPath), err.Error())
+	})
+	t.Run("no leftovers in module", func(t *testing.T) {
+		files, err := ioutil.ReadDir(tmpDir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 3, len(files))
+	})
+}
@@ -0,0 +1,209 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package codeblock
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/merrors"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	infoStringKeyValidate = "mdox-validate"
+	infoStringKeyType     = "mdox-type"
+	validateOff           = "off"
+)
+
+type validator struct {
+	types map[string]reflect.Type
+
+	errs map[string][]validationError
+}
+
+type validationError struct {
+	line int
+	lang string
+	err  error
+}
+
+// ValidatorOption is a functional option type for code block validator.
+type ValidatorOption func(*validator)
+
+// WithType registers Go type under given name, so YAML and JSON code blocks with `mdox-type=<name>` attribute
+// are validated against it, e.g ```yaml mdox-type=transform.Config. Unknown fields are not allowed, the same
+// as in config parsing with yaml.Decoder.KnownFields(true).
+func WithType(name string, typ interface{}) ValidatorOption {
+	return func(v *validator) {
+		v.types[name] = reflect.TypeOf(typ)
+	}
+}
+
+// NewValidator returns mdformatter.CodeBlockTransformer that validates syntax of YAML, JSON, TOML and Go code blocks.
+// Code blocks are not modified, all errors are reported on Close. Code blocks with `mdox-validate=off` attribute are
+// skipped, e.g ```yaml mdox-validate=off.
+func NewValidator(opts ...ValidatorOption) mdformatter.CodeBlockTransformer {
+	v := &validator{types: map[string]reflect.Type{}, errs: map[string][]validationError{}}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+func (v *validator) TransformCodeBlock(ctx mdformatter.SourceContext, infoString []byte, code []byte) ([]byte, error) {
+	lang, attrs, err := parseInfoString(infoString)
+	if err != nil {
+		return nil, err
+	}
+	if attrs[infoStringKeyValidate] == validateOff {
+		return code, nil
+	}
+
+	var typ reflect.Type
+	if name, ok := attrs[infoStringKeyType]; ok {
+		if typ, ok = v.types[name]; !ok {
+			return nil, errors.Errorf("unknown type %q in %v attribute; registered types: %v", name, infoStringKeyType, v.typeNames())
+		}
+	}
+
+	var line int
+	switch lang {
+	case "yaml", "yml":
+		line, err = validateYAML(code, typ)
+	case "json":
+		line, err = validateJSON(code, typ)
+	case "toml":
+		line, err = validateTOML(code)
+	case "go":
+		line, err = validateGo(code)
+	default:
+		return code, nil
+	}
+	if err != nil {
+		fenceLine, err2 := strconv.Atoi(ctx.LineNumbers)
+		if err2 != nil {
+			return nil, errors.Wrapf(err2, "unexpected line number %q of code block", ctx.LineNumbers)
+		}
+		v.errs[ctx.Filepath] = append(v.errs[ctx.Filepath], validationError{line: fenceLine + line, lang: lang, err: err})
+	}
+	return code, nil
+}
+
+func (v *validator) typeNames() []string {
+	names := make([]string, 0, len(v.types))
+	for n := range v.types {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (v *validator) Close(ctx mdformatter.SourceContext) error {
+	errs := v.errs[ctx.Filepath]
+	delete(v.errs, ctx.Filepath)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	path, err := relPath(ctx.Filepath)
+	if err != nil {
+		return err
+	}
+	merr := merrors.New()
+	for _, e := range errs {
+		merr.Add(errors.Wrapf(e.err, "%v:%v: invalid %v code block", path, e.line, e.lang))
+	}
+	return merr.Err()
+}
+
+// validateYAML checks all YAML documents from code. If typ is not nil, documents are decoded into new value
+// of that type with unknown fields disallowed. It returns line of the first error within code, if any.
+func validateYAML(code []byte, typ reflect.Type) (int, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(code))
+	dec.KnownFields(true)
+	for {
+		var err error
+		if typ != nil {
+			err = dec.Decode(reflect.New(typ).Interface())
+		} else {
+			err = dec.Decode(&yaml.Node{})
+		}
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			line := 0
+			if m := errLineRe.FindStringSubmatch(err.Error()); m != nil {
+				line, _ = strconv.Atoi(m[1])
+			}
+			return line, err
+		}
+	}
+}
+
+// validateJSON checks JSON code. If typ is not nil, code is decoded into new value of that type with unknown
+// fields disallowed. It returns line of the first error within code, if any.
+func validateJSON(code []byte, typ reflect.Type) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(code))
+	var err error
+	if typ != nil {
+		dec.DisallowUnknownFields()
+		err = dec.Decode(reflect.New(typ).Interface())
+	} else {
+		err = dec.Decode(&json.RawMessage{})
+	}
+	if err != nil {
+		offset := dec.InputOffset()
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			offset = e.Offset
+		case *json.UnmarshalTypeError:
+			offset = e.Offset
+		}
+		if offset > int64(len(code)) {
+			offset = int64(len(code))
+		}
+		return bytes.Count(code[:offset], []byte("\n")) + 1, err
+	}
+	if dec.More() {
+		return bytes.Count(code[:dec.InputOffset()], []byte("\n")) + 1, errors.New("unexpected data after top-level value")
+	}
+	return 0, nil
+}
+
+// validateTOML checks TOML code. It returns line of the first error within code, if any.
+func validateTOML(code []byte) (int, error) {
+	if _, err := toml.Decode(string(code), &map[string]interface{}{}); err != nil {
+		line := 0
+		if m := errLineRe.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		return line, err
+	}
+	return 0, nil
+}
+
+// validateGo checks syntax of Go code, which can be a whole file or just a fragment with declarations or statements.
+// It returns line of the first error within code, if any.
+func validateGo(code []byte) (int, error) {
+	s := wrapGoSnippet(code)
+	if _, err := parser.ParseFile(token.NewFileSet(), goSnippetFile, s.code, parser.AllErrors); err != nil {
+		if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+			return s.newError(list[0].Pos, "").line, errors.New(list[0].Msg)
+		}
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package codeblock
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/kit/log"
+)
+
+type testConfig struct {
+	Name  string   `yaml:"name" json:"name"`
+	Items []string `yaml:"items" json:"items"`
+}
+
+const testDocWithCodeToValidate = "# Code\n\n" +
+	"```yaml\na: 1\nb:\n  - c\n  d: e\n```\n\n" +
+	"```json\n{\n  \"a\": 1,\n  \"b\": [1, 2,]\n}\n```\n\n" +
+	"```toml\n[a]\nb = 1\nc = \n```\n\n" +
+	"```go\nfunc main() {\n\tx := \n}\n```\n\n" +
+	"```yaml mdox-type=testConfig\nname: yolo\nitems: [a, b]\nsize: 2\n```\n\n" +
+	"```json mdox-type=testConfig\n{\"name\": \"yolo\", \"items\": [\"a\"]}\n```\n\n" +
+	"```yaml mdox-validate=off\na: [\n```\n\n" +
+	"```go\nx := 1 + 2\n```\n"
+
+func TestValidator(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-codeblock-validate")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testFile := filepath.Join(tmpDir, "doc.md")
+	testutil.Ok(t, ioutil.WriteFile(testFile, []byte(testDocWithCodeToValidate), os.ModePerm))
+
+	wdir, err := os.Getwd()
+	testutil.Ok(t, err)
+	relPath, err := filepath.Rel(wdir, testFile)
+	testutil.Ok(t, err)
+
+	logger := log.NewLogfmtLogger(os.Stderr)
+	t.Run("invalid code blocks", func(t *testing.T) {
+		_, err := mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithCodeBlockTransformer(NewValidator(WithType("testConfig", testConfig{}))))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: 5 errors: "+
+			"%v:5: invalid yaml code block: yaml: line 2: did not find expected '-' indicator; "+
+			"%v:13: invalid json code block: invalid character ']' looking for beginning of value; "+
+			"%v:20: invalid toml code block: Near line 3 (last key parsed 'a.c'): expected value but found '\\n' instead; "+
+			"%v:26: invalid go code block: expected operand, found '}'; "+
+			"%v:32: invalid yaml code block: yaml: unmarshal errors:\n  line 3: field size not found in type codeblock.testConfig",
+			testFile, relPath, relPath, relPath, relPath, relPath), err.Error())
+	})
+	t.Run("unknown type", func(t *testing.T) {
+		_, err := mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithCodeBlockTransformer(NewValidator()))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("first formatting phase for %v: unknown type \"testConfig\" in mdox-type attribute; registered types: []", testFile), err.Error())
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package mdformatter
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// NodeRenderer renders node of kind unknown to markdown renderer (e.g added by goldmark extension) back to markdown.
+// Children contains markdown already rendered from each child of the node, in order, without leading new lines.
+type NodeRenderer func(source []byte, n ast.Node, children [][]byte) ([]byte, error)
+
+// WithGoldmarkExtensions adds goldmark extensions, e.g parsers of additional syntax, to both formatting phases. Nodes
+// of kinds added by extensions have to be rendered back to markdown with NodeRenderer registered via WithNodeRenderer.
+// GFM, footnotes, definition lists, math blocks and GitHub alerts are always supported.
+func WithGoldmarkExtensions(exts ...goldmark.Extender) Option {
+	return func(m *Formatter) {
+		m.extensions = append(m.extensions, exts...)
+	}
+}
+
+// WithASTTransformers adds goldmark AST transformers with priorities, e.g util.Prioritized(transformer, 100). They
+// are run after parsing in the first formatting phase only, so they are applied once.
+func WithASTTransformers(trs ...util.PrioritizedValue) Option {
+	return func(m *Formatter) {
+		m.astTransformers = append(m.astTransformers, trs...)
+	}
+}
+
+// WithNodeRenderer registers NodeRenderer for nodes of given kind, so they can be formatted.
+func WithNodeRenderer(kind ast.NodeKind, r NodeRenderer) Option {
+	return func(m *Formatter) {
+		if m.nodeRenderers == nil {
+			m.nodeRenderers = map[ast.NodeKind]NodeRenderer{}
+		}
+		m.nodeRenderers[kind] = r
+	}
+}
+
+// extendedRenderer is a Renderer that renders nodes of registered kinds with NodeRenderers and the rest of document
+// with wrapped renderer.
+type extendedRenderer struct {
+	wrapped renderer.Renderer
+	nodes   map[ast.NodeKind]NodeRenderer
+}
+
+func (extendedRenderer) AddOptions(...renderer.Option) {}
+
+func (r extendedRenderer) Render(w io.Writer, source []byte, node ast.Node) error {
+	if len(r.nodes) > 0 {
+		if err := r.renderNodes(source, node, map[ast.Node][]byte{}); err != nil {
+			return err
+		}
+	}
+	return r.wrapped.Render(w, source, node)
+}
+
+// renderNodes renders nodes of registered kinds in given subtree, from the deepest ones, and stores their markdown in
+// rendered. Nodes that are not children of other registered nodes are replaced with strings containing their markdown,
+// so the rest of nodes can be rendered by wrapped renderer.
+func (r extendedRenderer) renderNodes(source []byte, n ast.Node, rendered map[ast.Node][]byte) error {
+	for c := n.FirstChild(); c != nil; {
+		next := c.NextSibling()
+		if err := r.renderNodes(source, c, rendered); err != nil {
+			return err
+		}
+		c = next
+	}
+
+	render, ok := r.nodes[n.Kind()]
+	if !ok {
+		return nil
+	}
+	var children [][]byte
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if b, ok := rendered[c]; ok {
+			children = append(children, b)
+			continue
+		}
+		b := bytes.Buffer{}
+		if err := r.wrapped.Render(&b, source, c); err != nil {
+			return errors.Wrapf(err, "render child of %v", n.Kind())
+		}
+		children = append(children, bytes.TrimLeft(b.Bytes(), "\n"))
+	}
+	out, err := render(source, n, children)
+	if err != nil {
+		return errors.Wrapf(err, "render %v", n.Kind())
+	}
+	rendered[n] = out
+	if _, ok := r.nodes[n.Parent().Kind()]; ok {
+		// Parent renders it.
+		return nil
+	}
+
+	if n.Type() == ast.TypeBlock && n.PreviousSibling() != nil {
+		// Blocks rendered by wrapped renderer are separated with empty line.
+		out = append([]byte("\n\n"), out...)
+	}
+	n.Parent().ReplaceChild(n.Parent(), n, ast.NewString(out))
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package mdformatter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+type demoteHeadings struct{}
+
+func (demoteHeadings) Transform(doc *ast.Document, _ text.Reader, _ parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if h, ok := n.(*ast.Heading); ok && entering && h.Level < 6 {
+			h.Level++
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+var kindUnknown = ast.NewNodeKind("Unknown")
+
+type unknownNode struct {
+	ast.BaseBlock
+}
+
+func (*unknownNode) Kind() ast.NodeKind { return kindUnknown }
+
+func (n *unknownNode) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+// unknownNodes is an extension adding node of kind not enabled by default to the end of document.
+type unknownNodes struct{}
+
+func (unknownNodes) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(unknownNodes{}, 100)))
+}
+
+func (unknownNodes) Transform(doc *ast.Document, _ text.Reader, _ parser.Context) {
+	doc.AppendChild(doc, &unknownNode{})
+}
+
+func TestFormat_FormatSingle_Extensions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-extensions")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "doc.md"), []byte(`# Glossary
+
+Term 1
+: Description of *term 1*.
+
+Term 2
+: Description of [term 2](a.md).
+`), os.ModePerm))
+
+	format := func(opts ...Option) (string, error) {
+		file, err := os.OpenFile(filepath.Join(tmpDir, "doc.md"), os.O_RDONLY, 0)
+		testutil.Ok(t, err)
+		defer file.Close()
+
+		buf := bytes.Buffer{}
+		err = New(context.Background(), opts...).Format(file, &buf)
+		return buf.String(), err
+	}
+
+	t.Run("extension without node renderers", func(t *testing.T) {
+		_, err := format(WithGoldmarkExtensions(unknownNodes{}))
+		testutil.NotOk(t, err)
+	})
+	t.Run("default extensions", func(t *testing.T) {
+		out, err := format()
+		testutil.Ok(t, err)
+		testutil.Equals(t, `# Glossary
+
+Term 1
+: Description of *term 1*.
+
+Term 2
+: Description of [term 2](a.md).
+`, out)
+	})
+	t.Run("extension with node renderers and AST transformer", func(t *testing.T) {
+		out, err := format(
+			WithGoldmarkExtensions(extension.DefinitionList),
+			WithNodeRenderer(extast.KindDefinitionList, func(_ []byte, n ast.Node, children [][]byte) ([]byte, error) {
+				b := bytes.Buffer{}
+				for c, i := n.FirstChild(), 0; c != nil; c, i = c.NextSibling(), i+1 {
+					if i > 0 {
+						b.WriteString("\n")
+						if c.Kind() == extast.KindDefinitionTerm {
+							b.WriteString("\n")
+						}
+					}
+					b.Write(children[i])
+				}
+				return b.Bytes(), nil
+			}),
+			WithNodeRenderer(extast.KindDefinitionTerm, func(_ []byte, _ ast.Node, children [][]byte) ([]byte, error) {
+				return bytes.Join(children, nil), nil
+			}),
+			WithNodeRenderer(extast.KindDefinitionDescription, func(_ []byte, _ ast.Node, children [][]byte) ([]byte, error) {
+				return append([]byte(": "), bytes.Join(children, nil)...), nil
+			}),
+			WithASTTransformers(util.Prioritized(demoteHeadings{}, 100)),
+			WithLinkTransformer(&mockLinkTransformer{}),
+		)
+		testutil.Ok(t, err)
+		testutil.Equals(t, fmt.Sprintf(`## Glossary
+
+Term 1
+: Description of *term 1*.
+
+Term 2
+: Description of [term 2]($$-a.md-%s-$$).
+`, filepath.Join(tmpDir, "doc.md")), out)
+	})
+}
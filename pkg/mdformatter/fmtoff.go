@@ -0,0 +1,92 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package mdformatter
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	formatOffRe = regexp.MustCompile(`^<!--\s*mdox-fmt\s+off\s*-->$`)
+	formatOnRe  = regexp.MustCompile(`^<!--\s*mdox-fmt\s+on\s*-->$`)
+)
+
+// KindFormatOffRegion is a NodeKind of the FormatOffRegion node.
+var KindFormatOffRegion = ast.NewNodeKind("FormatOffRegion")
+
+// FormatOffRegion represents part of document between `<!-- mdox-fmt off -->` and `<!-- mdox-fmt on -->` markers,
+// which is kept as it is, including markers. Region without `on` marker lasts until the end of document. Its children
+// are blocks parsed from the region, so e.g links in it can be validated.
+type FormatOffRegion struct {
+	ast.BaseBlock
+
+	// Segment is a position of region in source.
+	Segment text.Segment
+}
+
+// Kind implements ast.Node.Kind.
+func (n *FormatOffRegion) Kind() ast.NodeKind { return KindFormatOffRegion }
+
+// Dump implements ast.Node.Dump.
+func (n *FormatOffRegion) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+// formatOffRegions groups blocks between formatting markers into FormatOffRegion nodes. Markers have to be HTML
+// comments in their own lines at the top level of document, not in lists or blockquotes.
+type formatOffRegions struct{}
+
+func (formatOffRegions) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(formatOffRegions{}, 100)))
+}
+
+func (formatOffRegions) Transform(doc *ast.Document, reader text.Reader, _ parser.Context) {
+	source := reader.Source()
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		off, ok := formatMarker(n, source, formatOffRe)
+		if !ok {
+			continue
+		}
+
+		region := &FormatOffRegion{Segment: text.NewSegment(off.Start, len(source))}
+		doc.InsertBefore(doc, n, region)
+		for c := n.NextSibling(); c != nil; {
+			next := c.NextSibling()
+			if on, ok := formatMarker(c, source, formatOnRe); ok {
+				region.Segment.Stop = on.Stop
+				doc.RemoveChild(doc, c)
+				break
+			}
+			region.AppendChild(region, c)
+			c = next
+		}
+		doc.RemoveChild(doc, n)
+		n = region
+	}
+}
+
+// formatMarker returns position of HTML block if it is a marker matching given regex.
+func formatMarker(n ast.Node, source []byte, re *regexp.Regexp) (text.Segment, bool) {
+	b, ok := n.(*ast.HTMLBlock)
+	if !ok || b.Lines().Len() == 0 {
+		return text.Segment{}, false
+	}
+	s := text.NewSegment(b.Lines().At(0).Start, b.Lines().At(b.Lines().Len()-1).Stop)
+	if b.HasClosure() {
+		s.Stop = b.ClosureLine.Stop
+	}
+	if !re.Match(bytes.TrimSpace(s.Value(source))) {
+		return text.Segment{}, false
+	}
+	return s, true
+}
+
+func renderFormatOffRegion(source []byte, n ast.Node, _ [][]byte) ([]byte, error) {
+	return bytes.TrimRight(n.(*FormatOffRegion).Segment.Value(source), "\n"), nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package mdformatter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+)
+
+type recordingLinkTransformer struct {
+	mockLinkTransformer
+
+	destinations []string
+}
+
+func (r *recordingLinkTransformer) TransformDestination(ctx SourceContext, destination []byte) ([]byte, error) {
+	r.destinations = append(r.destinations, string(destination))
+	return r.mockLinkTransformer.TransformDestination(ctx, destination)
+}
+
+func TestFormat_FormatSingle_FormatOffRegions(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-fmt-off")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "doc.md"), []byte(`# Doc
+
+Formatted   [link](a.md)
+text.
+
+<!-- mdox-fmt off -->
+| Name  | Link            |
+|-------|----------------:|
+| alpha | [b](b.md)       |
+
+  +---+     +---+
+  | A | --> | B |
+  +---+     +---+
+<!-- mdox-fmt on -->
+Formatted
+text.
+
+<!--mdox-fmt off-->
+
+Not   formatted [c](c.md)
+text.
+`), os.ModePerm))
+
+	format := func(opts ...Option) string {
+		file, err := os.OpenFile(filepath.Join(tmpDir, "doc.md"), os.O_RDONLY, 0)
+		testutil.Ok(t, err)
+		defer file.Close()
+
+		buf := bytes.Buffer{}
+		testutil.Ok(t, New(context.Background(), opts...).Format(file, &buf))
+		return buf.String()
+	}
+	exp := fmt.Sprintf(`# Doc
+
+Formatted [link]($$-a.md-%s-$$) text.
+
+<!-- mdox-fmt off -->
+| Name  | Link            |
+|-------|----------------:|
+| alpha | [b](b.md)       |
+
+  +---+     +---+
+  | A | --> | B |
+  +---+     +---+
+<!-- mdox-fmt on -->
+
+Formatted text.
+
+<!--mdox-fmt off-->
+
+Not   formatted [c](c.md)
+text.
+`, filepath.Join(tmpDir, "doc.md"))
+
+	t.Run("links in regions not transformed", func(t *testing.T) {
+		l := &recordingLinkTransformer{}
+		testutil.Equals(t, exp, format(WithLinkTransformer(l)))
+		testutil.Equals(t, []string{"a.md"}, l.destinations)
+		testutil.Assert(t, l.closed)
+	})
+	t.Run("links in regions transformed, but kept as they are", func(t *testing.T) {
+		l := &recordingLinkTransformer{}
+		testutil.Equals(t, exp, format(WithLinkTransformer(l), WithFormatOffRegionLinks()))
+		testutil.Equals(t, []string{"a.md", "b.md", "c.md"}, l.destinations)
+	})
+}
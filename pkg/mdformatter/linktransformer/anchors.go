@@ -0,0 +1,128 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter/slug"
+	"github.com/gohugoio/hugo/parser/pageparser"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"golang.org/x/net/html"
+)
+
+var (
+	htmlTagRe        = regexp.MustCompile(`<[a-zA-Z][^>]*>`)
+	htmlAnchorAttrRe = regexp.MustCompile(`\s(?:id|name)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// IsMarkdownFile returns true if given file path has markdown extension.
+func IsMarkdownFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// markdownAnchors returns all anchor targets (IDs) from given markdown content. Those are:
+// * IDs of ATX and setext headings, generated in given style and custom ones (`# Heading {#custom-id}`).
+// * `id` and `name` attributes of raw HTML elements, e.g `<a name="anchor"></a>`.
+// Front matter and code blocks are ignored.
+func markdownAnchors(content []byte, headingIDStyle slug.Style) ([]string, error) {
+	if fm, err := pageparser.ParseFrontMatterAndContent(bytes.NewReader(content)); err == nil && len(fm.FrontMatter) > 0 {
+		content = fm.Content
+	}
+
+	doc := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		// Parse headings the same way mdformatter does.
+		goldmark.WithParserOptions(parser.WithAttribute(), parser.WithHeadingAttribute()),
+	).Parser().Parse(text.NewReader(content))
+
+	ids := make([]string, 0)
+	slugger := slug.New(headingIDStyle)
+	if err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch typedNode := n.(type) {
+		case *ast.Heading:
+			headingText := string(typedNode.Text(content))
+			customID, ok := typedNode.AttributeString("id")
+			if !ok {
+				ids = append(ids, slugger.ID(headingText))
+				return ast.WalkSkipChildren, nil
+			}
+			// Parser strips custom ID from heading text, bring it back so style decides if it's respected.
+			// Custom ID is always a valid anchor, as most of the static site generators support it.
+			cid := string(customID.([]byte))
+			ids = append(ids, cid)
+			if id := slugger.ID(headingText + " {#" + cid + "}"); id != cid {
+				ids = append(ids, id)
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.HTMLBlock:
+			var html []byte
+			for i := 0; i < typedNode.Lines().Len(); i++ {
+				s := typedNode.Lines().At(i)
+				html = append(html, s.Value(content)...)
+			}
+			if typedNode.HasClosure() {
+				html = append(html, typedNode.ClosureLine.Value(content)...)
+			}
+			ids = append(ids, htmlAnchors(html)...)
+		case *ast.RawHTML:
+			var html []byte
+			for i := 0; i < typedNode.Segments.Len(); i++ {
+				s := typedNode.Segments.At(i)
+				html = append(html, s.Value(content)...)
+			}
+			ids = append(ids, htmlAnchors(html)...)
+		}
+		return ast.WalkContinue, nil
+	}); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// htmlAnchors returns values of `id` and `name` attributes from HTML tags in given raw HTML.
+func htmlAnchors(html []byte) (ids []string) {
+	for _, tag := range htmlTagRe.FindAll(html, -1) {
+		for _, m := range htmlAnchorAttrRe.FindAllSubmatch(tag, -1) {
+			ids = append(ids, string(bytes.Join(m[1:], nil)))
+		}
+	}
+	return ids
+}
+
+// htmlPageAnchors returns values of all `id` and `name` attributes from given HTML page.
+func htmlPageAnchors(page []byte) []string {
+	ids := make([]string, 0)
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// Either io.EOF or malformed HTML, take what we have found so far.
+			return ids
+		case html.StartTagToken, html.SelfClosingTagToken:
+			_, more := z.TagName()
+			for more {
+				var key, val []byte
+				key, val, more = z.TagAttr()
+				if k := string(key); k == "id" || k == "name" {
+					ids = append(ids, string(val))
+				}
+			}
+		}
+	}
+}
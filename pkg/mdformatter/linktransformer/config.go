@@ -8,10 +8,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 
+	"github.com/gocolly/colly/v2"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
@@ -23,25 +26,104 @@ type Config struct {
 }
 
 type ValidatorConfig struct {
-	// Regex for type github is reponame matcher, like `bwplotka\/mdox`.
+	// Regex for type github and githubLocal is reponame matcher, like `bwplotka\/mdox`.
 	Regex string `yaml:"regex"`
-	// By default type is `ignore`. Could be `github` or `roundtrip`.
+	// By default type is `ignore`. Could be `github`, `githubLocal` or `roundtrip`.
 	Type ValidatorType `yaml:"type"`
+	// Severity of invalid links matched by this validator. Could be `error` (default), `warn` or `info`. Invalid links
+	// fail validation only if severity is at least `--links.validate.fail-on` threshold, otherwise they are logged.
+	Severity Severity `yaml:"severity"`
 	// GitHub repo token to avoid getting rate limited.
 	Token string `yaml:"token"`
+	// MaxNumber is the latest issue or pull request number of GitHub repo for `github` type. If specified,
+	// GitHub API is never called.
+	MaxNumber int `yaml:"maxNumber"`
+	// StateFile is a path to YAML file for `github` type, where the latest issue or pull request numbers fetched
+	// from GitHub API are stored per repo. GitHub API is called only if link with higher number is found.
+	StateFile string `yaml:"stateFile"`
+	// CheckCommits enables checking commit links for `github` type in local git repository of anchor dir instead of
+	// visiting them. Only links to repos that are GitHub remotes of the local repository are checked this way, commit
+	// links to other repos are visited. Make sure commits are fetched, e.g clone is not shallow.
+	CheckCommits bool `yaml:"checkCommits"`
+	// Ref is a git ref (e.g branch `main`) for `githubLocal` type, links at which are checked in working tree, as it's
+	// the version of the ref being changed. Links at other refs are checked at those refs in local repository, or
+	// visited if ref does not exist locally. By default, all links are checked at their refs.
+	Ref string `yaml:"ref"`
+	// CheckFragments enables checking if HTML page has element with ID (or name) from the URL fragment
+	// e.g `https://example.com/docs#section`. Percent-encoded fragments are decoded. For github.com pages, IDs with
+	// `user-content-` prefix, as GitHub renders them, match fragments without it. Only supported by `roundtrip` type.
+	CheckFragments bool `yaml:"checkFragments"`
+
+	// HTTP policy, only supported by `roundtrip` type.
+
+	// Timeout of a single request, e.g `30s`. Default is 10s.
+	Timeout time.Duration `yaml:"timeout"`
+	// Retry configures retries of failed requests. By default, requests are retried once on 301, 307, 429
+	// and 503 status codes.
+	Retry *RetryConfig `yaml:"retry"`
+	// RateLimits limit requests to domains matching given globs. The first matching limit is used. Domains not
+	// matching any limit are requested with parallelism of 100.
+	RateLimits []RateLimitConfig `yaml:"rateLimits"`
+	// AcceptStatusCodes are error status codes that are treated as valid link, e.g 403 for sites that block bots.
+	AcceptStatusCodes []int `yaml:"acceptStatusCodes"`
+	// Headers are set in requests to URLs matching regex only, e.g `Authorization: 'Bearer $(TOKEN)'`. They are
+	// not sent further if request is redirected to URL not matching regex. Headers are never logged.
+	Headers map[string]string `yaml:"headers"`
+	// HeadFirst enables checking links with HEAD requests, so response bodies are not downloaded. If server rejects
+	// HEAD request as not allowed or not implemented, link is checked with GET request. It can't be used with
+	// CheckFragments, as it needs bodies.
+	HeadFirst bool `yaml:"headFirst"`
+	// MaxBodySize is a maximum number of bytes of response body to read, e.g for GET requests. Default is 10MB.
+	MaxBodySize int `yaml:"maxBodySize"`
 
 	ghValidator GitHubValidator
+	glValidator GitHubLocalValidator
 	rtValidator RoundTripValidator
 	igValidator IgnoreValidator
 }
 
+type RetryConfig struct {
+	// Max is a maximum number of retries of a single request.
+	Max int `yaml:"max"`
+	// MinBackoff is a wait time before the first retry, doubled for each subsequent one, e.g `1s`. Actual wait time
+	// is randomized (jitter) between half and full backoff. Retry-After header of 429 responses takes precedence.
+	// Default is 1s.
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	// MaxBackoff is a maximum wait time before retry. Default is 30s.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// TransportErrors enables retries of requests that failed without response, e.g on timeout or connection reset.
+	TransportErrors bool `yaml:"transportErrors"`
+}
+
+type RateLimitConfig struct {
+	// DomainGlob is a glob matching domains e.g `*github.com`.
+	DomainGlob string `yaml:"domainGlob"`
+	// Parallelism is a maximum number of concurrent requests to all matching domains.
+	Parallelism int `yaml:"parallelism"`
+	// Delay is a wait time between requests to matching domains.
+	Delay time.Duration `yaml:"delay"`
+	// RandomDelay is an additional random wait time added to Delay.
+	RandomDelay time.Duration `yaml:"randomDelay"`
+}
+
 type RoundTripValidator struct {
-	_regex *regexp.Regexp
+	_regex          *regexp.Regexp
+	_checkFragments bool
+	_headFirst      bool
+	// _c is a collector with validator's own HTTP policy. If nil, the default one is used.
+	_c *colly.Collector
 }
 
 type GitHubValidator struct {
 	_regex  *regexp.Regexp
-	_maxNum int
+	_maxNum *gitHubMaxNumber
+	// _commits is nil if commit links are not checked.
+	_commits *localCommits
+}
+
+type GitHubLocalValidator struct {
+	_regex *regexp.Regexp
+	_repo  *localGitHubRepo
 }
 
 type IgnoreValidator struct {
@@ -50,10 +132,37 @@ type IgnoreValidator struct {
 
 type ValidatorType string
 
+// Severity represents importance of invalid link.
+type Severity string
+
 const (
-	roundtripValidator ValidatorType = "roundtrip"
-	githubValidator    ValidatorType = "github"
-	ignoreValidator    ValidatorType = "ignore"
+	SeverityError Severity = "error"
+	SeverityWarn  Severity = "warn"
+	SeverityInfo  Severity = "info"
+)
+
+// Severities contains all severities, from the most important one.
+var Severities = []Severity{SeverityError, SeverityWarn, SeverityInfo}
+
+// AtLeast returns true if severity is the same or more important than given one.
+func (s Severity) AtLeast(o Severity) bool {
+	return s.rank() >= o.rank()
+}
+
+func (s Severity) rank() int {
+	for i, sev := range Severities {
+		if sev == s {
+			return len(Severities) - i
+		}
+	}
+	return 0
+}
+
+const (
+	roundtripValidator   ValidatorType = "roundtrip"
+	githubValidator      ValidatorType = "github"
+	githubLocalValidator ValidatorType = "githubLocal"
+	ignoreValidator      ValidatorType = "ignore"
 )
 
 const (
@@ -69,7 +178,8 @@ func ParseConfig(c []byte) (Config, error) {
 	dec := yaml.NewDecoder(bytes.NewReader(c))
 	dec.KnownFields(true)
 	if err := dec.Decode(&cfg); err != nil {
-		return Config{}, errors.Wrapf(err, "parsing YAML content %q", string(c))
+		// Don't print content, as it might contain secrets.
+		return Config{}, errors.Wrap(err, "parsing YAML content")
 	}
 
 	if len(cfg.Validators) <= 0 {
@@ -78,16 +188,43 @@ func ParseConfig(c []byte) (Config, error) {
 
 	// Evaluate regex for given validators.
 	for i := range cfg.Validators {
+		if cfg.Validators[i].Type != roundtripValidator && (cfg.Validators[i].CheckFragments || cfg.Validators[i].hasHTTPPolicy()) {
+			return Config{}, errors.Errorf("checkFragments and HTTP policy are supported only by %v validator, got %v", roundtripValidator, cfg.Validators[i].Type)
+		}
+		if cfg.Validators[i].HeadFirst && cfg.Validators[i].CheckFragments {
+			return Config{}, errors.New("headFirst can't be used with checkFragments, checking fragments needs response bodies")
+		}
+		if cfg.Validators[i].Type != githubValidator && cfg.Validators[i].CheckCommits {
+			return Config{}, errors.Errorf("checkCommits is supported only by %v validator, got %v", githubValidator, cfg.Validators[i].Type)
+		}
+		switch cfg.Validators[i].Severity {
+		case "":
+			cfg.Validators[i].Severity = SeverityError
+		case SeverityError, SeverityWarn, SeverityInfo:
+		default:
+			return Config{}, errors.Errorf("severity %q not supported, expected one of %v", cfg.Validators[i].Severity, Severities)
+		}
 		switch cfg.Validators[i].Type {
 		case roundtripValidator:
 			cfg.Validators[i].rtValidator._regex = regexp.MustCompile(cfg.Validators[i].Regex)
+			cfg.Validators[i].rtValidator._checkFragments = cfg.Validators[i].CheckFragments
+			cfg.Validators[i].rtValidator._headFirst = cfg.Validators[i].HeadFirst
 		case githubValidator:
-			regex, maxNum, err := getGitHubRegex(cfg.Validators[i].Regex, cfg.Validators[i].Token)
+			regex, repo, err := getGitHubRegex(cfg.Validators[i].Regex, cfg.Validators[i].CheckCommits)
 			if err != nil {
 				return Config{}, errors.Wrapf(err, "parsing GitHub Regex %v", err)
 			}
 			cfg.Validators[i].ghValidator._regex = regex
-			cfg.Validators[i].ghValidator._maxNum = maxNum
+			if cfg.Validators[i].CheckCommits {
+				cfg.Validators[i].ghValidator._commits = newLocalCommits()
+			}
+			cfg.Validators[i].ghValidator._maxNum, err = newGitHubMaxNumber(repo, cfg.Validators[i].Token, cfg.Validators[i].MaxNumber, cfg.Validators[i].StateFile)
+			if err != nil {
+				return Config{}, err
+			}
+		case githubLocalValidator:
+			cfg.Validators[i].glValidator._regex = getGitHubLocalRegex(cfg.Validators[i].Regex)
+			cfg.Validators[i].glValidator._repo = &localGitHubRepo{ref: cfg.Validators[i].Ref}
 		case ignoreValidator:
 			cfg.Validators[i].igValidator._regex = regexp.MustCompile(cfg.Validators[i].Regex)
 		default:
@@ -97,16 +234,73 @@ func ParseConfig(c []byte) (Config, error) {
 	return cfg, nil
 }
 
-// getGitHubRegex returns GitHub pulls/issues regex from repo name.
-func getGitHubRegex(repoRe string, repoToken string) (*regexp.Regexp, int, error) {
+func (v ValidatorConfig) hasHTTPPolicy() bool {
+	return v.Timeout > 0 || v.Retry != nil || len(v.RateLimits) > 0 || len(v.AcceptStatusCodes) > 0 || len(v.Headers) > 0 ||
+		v.HeadFirst || v.MaxBodySize > 0
+}
+
+var defaultRetryConfig = RetryConfig{Max: 1}
+
+// retryable returns true if request with given response status code should be retried.
+func (r RetryConfig) retryable(statusCode int) bool {
+	switch statusCode {
+	case 0:
+		return r.TransportErrors
+	case http.StatusMovedPermanently, http.StatusTemporaryRedirect, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// backoff returns wait time before next retry, given number of retries so far.
+func (r RetryConfig) backoff(retries int, response *colly.Response) time.Duration {
+	if response.StatusCode == http.StatusTooManyRequests && response.Headers != nil {
+		if retryAfterSeconds, err := strconv.Atoi(response.Headers.Get("Retry-After")); err == nil {
+			return time.Duration(retryAfterSeconds) * time.Second
+		}
+	}
+
+	minBackoff, maxBackoff := r.MinBackoff, r.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = 1 * time.Second
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+	backoff := minBackoff
+	for i := 0; i < retries && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	// Equal jitter, so concurrent retries are spread, but still backoff.
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// getGitHubRegex returns GitHub pulls/issues (and optionally commits) regex and repo name from repo name regex.
+// Kind of link is captured by `kind` group.
+func getGitHubRegex(repoRe string, commits bool) (*regexp.Regexp, string, error) {
 	// Get reponame from regex.
 	getRepo := regexp.MustCompile(`(?P<org>[A-Za-z0-9_.-]+)\\\/(?P<repo>[A-Za-z0-9_.-]+)`)
 	match := getRepo.FindStringSubmatch(repoRe)
 	if len(match) != 3 {
-		return nil, math.MaxInt64, errors.New("repo name regex not valid")
+		return nil, "", errors.New("repo name regex not valid")
+	}
+	kinds := `\/pull\/|\/issues\/`
+	if commits {
+		kinds += `|\/commit\/`
 	}
-	reponame := match[1] + "/" + match[2]
+	return regexp.MustCompile(`(^http[s]?:\/\/)(www\.)?(github\.com\/)(` + repoRe + `)(?P<kind>` + kinds + `)`), match[1] + "/" + match[2], nil
+}
 
+// getGitHubLocalRegex returns regex matching blob, tree and raw links to files of GitHub repo matching given regex.
+func getGitHubLocalRegex(repoRe string) *regexp.Regexp {
+	return regexp.MustCompile(`(^http[s]?:\/\/)(www\.)?(github\.com\/)(` + repoRe + `)\/(blob|tree|raw)\/`)
+}
+
+// getGitHubMaxNumber returns the latest pull request or issue number of given GitHub repo using GitHub API.
+func getGitHubMaxNumber(reponame string, repoToken string) (int, error) {
 	var pullNum []GitHubResponse
 	var issueNum []GitHubResponse
 	max := 0
@@ -116,14 +310,14 @@ func getGitHubRegex(repoRe string, repoToken string) (*regexp.Regexp, int, error
 	// Check latest pull request number.
 	reqPull, err := http.NewRequest("GET", fmt.Sprintf(gitHubAPIURL, reponame, "pulls"), nil)
 	if err != nil {
-		return nil, math.MaxInt64, err
+		return math.MaxInt64, err
 	}
 	reqPull.Header.Set("User-Agent", "mdox")
 
 	// Check latest issue number and return whichever is greater.
 	reqIssue, err := http.NewRequest("GET", fmt.Sprintf(gitHubAPIURL, reponame, "issues"), nil)
 	if err != nil {
-		return nil, math.MaxInt64, err
+		return math.MaxInt64, err
 	}
 	reqIssue.Header.Set("User-Agent", "mdox")
 
@@ -134,26 +328,26 @@ func getGitHubRegex(repoRe string, repoToken string) (*regexp.Regexp, int, error
 
 	respPull, err := client.Do(reqPull)
 	if err != nil {
-		return nil, math.MaxInt64, err
+		return math.MaxInt64, err
 	}
 	if respPull.StatusCode != 200 {
-		return nil, math.MaxInt64, errors.New("pulls API request failed. status code: " + strconv.Itoa(respPull.StatusCode))
+		return math.MaxInt64, errors.New("pulls API request failed. status code: " + strconv.Itoa(respPull.StatusCode))
 	}
 	defer respPull.Body.Close()
 	if err := json.NewDecoder(respPull.Body).Decode(&pullNum); err != nil {
-		return nil, math.MaxInt64, err
+		return math.MaxInt64, err
 	}
 
 	respIssue, err := client.Do(reqIssue)
 	if err != nil {
-		return nil, math.MaxInt64, err
+		return math.MaxInt64, err
 	}
 	if respIssue.StatusCode != 200 {
-		return nil, math.MaxInt64, errors.New("issues API request failed. status code: " + strconv.Itoa(respIssue.StatusCode))
+		return math.MaxInt64, errors.New("issues API request failed. status code: " + strconv.Itoa(respIssue.StatusCode))
 	}
 	defer respIssue.Body.Close()
 	if err := json.NewDecoder(respIssue.Body).Decode(&issueNum); err != nil {
-		return nil, math.MaxInt64, err
+		return math.MaxInt64, err
 	}
 
 	if len(pullNum) > 0 {
@@ -162,6 +356,5 @@ func getGitHubRegex(repoRe string, repoToken string) (*regexp.Regexp, int, error
 	if len(issueNum) > 0 && issueNum[0].Number > max {
 		max = issueNum[0].Number
 	}
-
-	return regexp.MustCompile(`(^http[s]?:\/\/)(www\.)?(github\.com\/)(` + repoRe + `)(\/pull\/|\/issues\/)`), max, nil
+	return max, nil
 }
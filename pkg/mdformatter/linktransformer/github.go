@@ -0,0 +1,332 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// gitHubStateMu guards all state files, as multiple validators might use the same one.
+var gitHubStateMu sync.Mutex
+
+// gitHubMaxNumber resolves the latest issue or pull request number of GitHub repo lazily, so GitHub API is
+// called only when needed.
+type gitHubMaxNumber struct {
+	repo, token, stateFile string
+	static                 int
+
+	mu      sync.Mutex
+	num     int
+	fetched bool
+	err     error
+}
+
+func newGitHubMaxNumber(repo, token string, static int, stateFile string) (*gitHubMaxNumber, error) {
+	m := &gitHubMaxNumber{repo: repo, token: token, static: static, stateFile: stateFile}
+	if static > 0 || stateFile == "" {
+		return m, nil
+	}
+
+	gitHubStateMu.Lock()
+	defer gitHubStateMu.Unlock()
+	state, err := readGitHubState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	m.num = state[repo]
+	return m, nil
+}
+
+// get returns the latest issue or pull request number. If known number is lower than atLeast, it is fetched
+// from GitHub API, but only once.
+func (m *gitHubMaxNumber) get(atLeast int) (int, error) {
+	if m.static > 0 {
+		return m.static, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.num >= atLeast || m.fetched {
+		return m.num, m.err
+	}
+
+	m.fetched = true
+	num, err := getGitHubMaxNumber(m.repo, m.token)
+	if err != nil {
+		m.err = errors.Wrapf(err, "get the latest issue or pull request number of %v from GitHub API", m.repo)
+		return m.num, m.err
+	}
+	m.num = num
+	if m.stateFile == "" {
+		return m.num, nil
+	}
+
+	gitHubStateMu.Lock()
+	defer gitHubStateMu.Unlock()
+	state, err := readGitHubState(m.stateFile)
+	if err != nil {
+		return m.num, err
+	}
+	state[m.repo] = m.num
+	b, err := yaml.Marshal(state)
+	if err != nil {
+		return m.num, errors.Wrap(err, "marshal GitHub state")
+	}
+	return m.num, errors.Wrapf(ioutil.WriteFile(m.stateFile, b, 0644), "write GitHub state file %v", m.stateFile)
+}
+
+// readGitHubState reads state file with the latest issue or pull request numbers per repo. Not existing file
+// means empty state.
+func readGitHubState(stateFile string) (map[string]int, error) {
+	state := map[string]int{}
+	b, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, errors.Wrapf(err, "read GitHub state file %v", stateFile)
+	}
+	if err := yaml.Unmarshal(b, &state); err != nil {
+		return nil, errors.Wrapf(err, "parse GitHub state file %v", stateFile)
+	}
+	return state, nil
+}
+
+// gitHubRemoteRe matches GitHub URL of git remote and captures its org/repo, e.g `git@github.com:bwplotka/mdox.git`.
+var gitHubRemoteRe = regexp.MustCompile(`github\.com[:/]([^/\s]+/[^/\s]+?)(?:\.git)?(?:\s|$)`)
+
+// localCommits checks existence of commits in local git repository. Results are cached per commit.
+type localCommits struct {
+	once    sync.Once
+	remotes map[string]struct{}
+	err     error
+
+	mu     sync.Mutex
+	exists map[string]error
+}
+
+func newLocalCommits() *localCommits {
+	return &localCommits{exists: map[string]error{}}
+}
+
+// isLocal returns true if given GitHub org/repo is a remote of git repository of given dir. Remotes are resolved
+// only once.
+func (l *localCommits) isLocal(dir string, repo string) (bool, error) {
+	l.once.Do(func() {
+		stderr := bytes.Buffer{}
+		cmd := exec.Command("git", "remote", "-v")
+		cmd.Dir = dir
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			l.err = errors.Wrapf(err, "list remotes of local git repository: %v", bytes.TrimSpace(stderr.Bytes()))
+			return
+		}
+		l.remotes = map[string]struct{}{}
+		for _, m := range gitHubRemoteRe.FindAllSubmatch(out, -1) {
+			l.remotes[strings.ToLower(string(m[1]))] = struct{}{}
+		}
+	})
+	if l.err != nil {
+		return false, l.err
+	}
+	_, ok := l.remotes[strings.ToLower(repo)]
+	return ok, nil
+}
+
+// check returns error if commit with given SHA does not exist in git repository of given dir.
+func (l *localCommits) check(dir string, sha string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err, ok := l.exists[sha]; ok {
+		return err
+	}
+
+	var err error
+	stderr := bytes.Buffer{}
+	cmd := exec.Command("git", "cat-file", "-e", sha+"^{commit}")
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	if runErr := cmd.Run(); runErr != nil {
+		err = errors.Wrapf(runErr, "check commit %v in local git repository: %v", sha, bytes.TrimSpace(stderr.Bytes()))
+		if bytes.Contains(stderr.Bytes(), []byte("Not a valid object name")) {
+			err = errors.Errorf("commit %v not found in local git repository (shallow clone?)", sha)
+		}
+	}
+	l.exists[sha] = err
+	return err
+}
+
+// Special git refs resolved by ResolveGitRef.
+const (
+	GitRefHead = "HEAD"
+	GitRefTag  = "tag"
+)
+
+// ResolveGitRef returns given git ref, unless it's GitRefHead or GitRefTag. Those are resolved in git repository of
+// given dir to commit SHA of HEAD and to the latest tag reachable from HEAD respectively.
+func ResolveGitRef(dir string, ref string) (string, error) {
+	var args []string
+	switch ref {
+	case GitRefHead:
+		args = []string{"rev-parse", "HEAD"}
+	case GitRefTag:
+		args = []string{"describe", "--tags", "--abbrev=0"}
+	default:
+		return ref, nil
+	}
+
+	stderr := bytes.Buffer{}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "resolve %v git ref in %v: %v", ref, dir, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// localGitHubRepo reads files of GitHub repo from its local clone, either from working tree or at given git ref.
+type localGitHubRepo struct {
+	// ref is a git ref, which links are checked in working tree instead of at this ref.
+	ref string
+
+	once sync.Once
+	root string
+	err  error
+
+	mu   sync.Mutex
+	refs map[string]bool
+}
+
+// topLevel returns root directory of git repository containing given dir. It's resolved only once.
+func (l *localGitHubRepo) topLevel(dir string) (string, error) {
+	l.once.Do(func() {
+		stderr := bytes.Buffer{}
+		cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+		cmd.Dir = dir
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			l.err = errors.Wrapf(err, "find local git repository of %v: %v", dir, bytes.TrimSpace(stderr.Bytes()))
+			return
+		}
+		l.root = string(bytes.TrimSpace(out))
+	})
+	return l.root, l.err
+}
+
+// splitRef splits ref and path of link to file, e.g `main/pkg/a.go`. Refs might contain '/', so the shortest prefix
+// which is a ref existing in local repository is chosen. Links at ref the repo was configured with are read from working
+// tree, which is marked by empty ref. If no ref exists in local repository, ok is false.
+func (l *localGitHubRepo) splitRef(dir string, refPath string) (ref string, path string, ok bool, err error) {
+	if l.ref != "" && (refPath == l.ref || strings.HasPrefix(refPath, l.ref+"/")) {
+		return "", strings.TrimPrefix(strings.TrimPrefix(refPath, l.ref), "/"), true, nil
+	}
+	root, err := l.topLevel(dir)
+	if err != nil {
+		return "", "", false, err
+	}
+	parts := strings.Split(refPath, "/")
+	for i := 1; i <= len(parts); i++ {
+		ref := strings.Join(parts[:i], "/")
+		if ref == "" {
+			continue
+		}
+		exists, err := l.refExists(root, ref)
+		if err != nil {
+			return "", "", false, err
+		}
+		if exists {
+			return ref, strings.Join(parts[i:], "/"), true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// refExists returns true if given ref points to commit in local repository. Result is cached per ref.
+func (l *localGitHubRepo) refExists(root string, ref string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if exists, ok := l.refs[ref]; ok {
+		return exists, nil
+	}
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Dir = root
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return false, errors.Wrapf(err, "git rev-parse %v", ref)
+	}
+	if l.refs == nil {
+		l.refs = map[string]bool{}
+	}
+	l.refs[ref] = err == nil
+	return l.refs[ref], nil
+}
+
+// read returns content of file under given slash separated path, relative to repository root of given dir, at given
+// ref or in working tree if ref is empty. For directories, nil content and isDir true is returned. If path does not
+// exist, errNotFound is returned.
+func (l *localGitHubRepo) read(dir string, ref string, path string) (content []byte, isDir bool, err error) {
+	root, err := l.topLevel(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	if ref == "" {
+		file := filepath.Join(root, filepath.FromSlash(path))
+		st, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, false, errNotFound
+			}
+			return nil, false, err
+		}
+		if st.IsDir() {
+			return nil, true, nil
+		}
+		content, err = ioutil.ReadFile(file)
+		return content, false, err
+	}
+
+	obj := ref + ":" + path
+	typ, err := l.git(root, "cat-file", "-t", obj)
+	if err != nil {
+		return nil, false, err
+	}
+	if string(bytes.TrimSpace(typ)) == "tree" {
+		return nil, true, nil
+	}
+	content, err = l.git(root, "cat-file", "blob", obj)
+	return content, false, err
+}
+
+var errNotFound = errors.New("not found")
+
+// git runs git command in given repository root and returns its output. Missing path is returned as errNotFound.
+func (l *localGitHubRepo) git(root string, args ...string) ([]byte, error) {
+	stderr := bytes.Buffer{}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = root
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if bytes.Contains(stderr.Bytes(), []byte("does not exist in")) || bytes.Contains(stderr.Bytes(), []byte("exists on disk, but not in")) {
+			return nil, errNotFound
+		}
+		return nil, errors.Wrapf(err, "git %v: %v", strings.Join(args, " "), bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
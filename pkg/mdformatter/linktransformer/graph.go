@@ -0,0 +1,278 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+)
+
+// schemeRe matches links with scheme, e.g `https://` or `mailto:`.
+var schemeRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+// indexFiles are files rendered when directory is linked, in order of precedence.
+var indexFiles = []string{"README.md", "readme.md", "index.md", "_index.md"}
+
+// Edge represents a link found in markdown file, from the file to linked file or URL.
+type Edge struct {
+	// From is an absolute path of markdown file with the link.
+	From string
+	// Lines are numbers of lines with the link, e.g `3` or `3,7`.
+	Lines string
+	// Destination is the link as written in the file.
+	Destination string
+	// To is an absolute path of linked local file or directory, without query and fragment. Empty for remote links.
+	To string
+}
+
+// Graph is a mdformatter.LinkTransformer that does not change any link, but collects all of them, so links between
+// files can be analysed.
+type Graph struct {
+	anchorDir string
+
+	mu    sync.Mutex
+	edges []Edge
+}
+
+// NewGraph returns Graph that resolves local links against given anchor dir.
+func NewGraph(anchorDir string) *Graph {
+	return &Graph{anchorDir: anchorDir}
+}
+
+func (g *Graph) TransformDestination(ctx mdformatter.SourceContext, destination []byte) ([]byte, error) {
+	l := Edge{From: ctx.Filepath, Lines: ctx.LineNumbers, Destination: string(destination)}
+	if !schemeRe.Match(destination) {
+		// Query does not change linked file, e.g `img.png?raw=true`.
+		l.To, _ = splitQuery(splitLocalLink(absLocalLink(g.anchorDir, ctx.Filepath, string(destination)))[0])
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.edges = append(g.edges, l)
+	return destination, nil
+}
+
+func (g *Graph) Close(mdformatter.SourceContext) error { return nil }
+
+// Edges returns all collected links, in order they were found.
+func (g *Graph) Edges() []Edge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Edge(nil), g.edges...)
+}
+
+// Orphans returns those of given files that are not linked from any other file. Links to directory are treated as
+// links to its index file, e.g `README.md`. Files for which isEntrypoint returns true are never orphans.
+func (g *Graph) Orphans(files []string, isEntrypoint func(file string) bool) []string {
+	linked := map[string]struct{}{}
+	for _, l := range g.Edges() {
+		if l.To == "" || l.To == l.From {
+			continue
+		}
+		linked[l.To] = struct{}{}
+		if st, err := os.Stat(l.To); err == nil && st.IsDir() {
+			if index := indexFile(l.To); index != "" {
+				linked[index] = struct{}{}
+			}
+		}
+	}
+
+	var orphans []string
+	for _, f := range files {
+		if _, ok := linked[f]; ok || isEntrypoint(f) {
+			continue
+		}
+		orphans = append(orphans, f)
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// indexFile returns path of file rendered when given directory is linked or empty string if there is none.
+func indexFile(dir string) string {
+	for _, name := range indexFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name)
+		}
+	}
+	return ""
+}
+
+// Backlinks returns links to given local file or directory from other files, in order they were found. Links to
+// directory are returned for its index file too.
+func (g *Graph) Backlinks(path string) []Edge {
+	var res []Edge
+	for _, l := range g.Edges() {
+		if l.To == "" || l.From == path {
+			continue
+		}
+		if l.To == path || indexFile(l.To) == path {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// Referrers returns sorted files with links to any of given files or directories, including files in them.
+func (g *Graph) Referrers(paths ...string) []string {
+	set := map[string]struct{}{}
+	for _, l := range g.Edges() {
+		for _, p := range paths {
+			if l.To != "" && isWithinDir(p, l.To) {
+				set[l.From] = struct{}{}
+			}
+		}
+	}
+	res := make([]string, 0, len(set))
+	for f := range set {
+		res = append(res, f)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Node types of exported graph.
+const (
+	NodeDocument  = "document"
+	NodeFile      = "file"
+	NodeDirectory = "directory"
+	NodeMissing   = "missing"
+	NodeRemote    = "remote"
+)
+
+type jsonGraph struct {
+	Nodes []jsonNode `json:"nodes"`
+	Links []jsonLink `json:"links"`
+}
+
+type jsonNode struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+type jsonLink struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Lines       []int  `json:"lines"`
+	Destination string `json:"destination"`
+}
+
+// node returns ID and type of node for given local path or, if empty, remote destination. Local paths are relative
+// to anchor dir.
+func (g *Graph) node(path string, destination string) (string, string) {
+	if path == "" {
+		url, _ := splitFragment(destination)
+		return url, NodeRemote
+	}
+
+	id := path
+	if rel, err := filepath.Rel(g.anchorDir, path); err == nil {
+		id = filepath.ToSlash(rel)
+	}
+	st, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return id, NodeMissing
+	case st.IsDir():
+		return id, NodeDirectory
+	case IsMarkdownFile(path):
+		return id, NodeDocument
+	default:
+		return id, NodeFile
+	}
+}
+
+func (g *Graph) jsonGraph(links []Edge) jsonGraph {
+	res := jsonGraph{Nodes: []jsonNode{}, Links: []jsonLink{}}
+	seen := map[string]struct{}{}
+	addNode := func(path string, destination string) string {
+		id, typ := g.node(path, destination)
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			res.Nodes = append(res.Nodes, jsonNode{ID: id, Type: typ})
+		}
+		return id
+	}
+	for _, l := range links {
+		jl := jsonLink{From: addNode(l.From, ""), To: addNode(l.To, l.Destination), Destination: l.Destination, Lines: []int{}}
+		for _, line := range strings.Split(l.Lines, ",") {
+			if n, err := strconv.Atoi(line); err == nil {
+				jl.Lines = append(jl.Lines, n)
+			}
+		}
+		res.Links = append(res.Links, jl)
+	}
+	return res
+}
+
+// WriteJSON writes given links and files they connect as JSON graph.
+func (g *Graph) WriteJSON(w io.Writer, links []Edge) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g.jsonGraph(links))
+}
+
+// WriteDOT writes given links as Graphviz DOT directed graph, with single edge between each pair of files.
+func (g *Graph) WriteDOT(w io.Writer, links []Edge) error {
+	jg := g.jsonGraph(links)
+
+	b := strings.Builder{}
+	b.WriteString("digraph mdox {\n")
+	for _, n := range jg.Nodes {
+		attrs := "shape=box"
+		switch n.Type {
+		case NodeRemote:
+			attrs = "shape=ellipse, color=gray"
+		case NodeMissing:
+			attrs = "shape=box, color=red"
+		case NodeDirectory:
+			attrs = "shape=folder"
+		}
+		fmt.Fprintf(&b, "  %v [%v];\n", strconv.Quote(n.ID), attrs)
+	}
+	seen := map[[2]string]struct{}{}
+	for _, l := range jg.Links {
+		if _, ok := seen[[2]string{l.From, l.To}]; ok {
+			continue
+		}
+		seen[[2]string{l.From, l.To}] = struct{}{}
+		fmt.Fprintf(&b, "  %v -> %v;\n", strconv.Quote(l.From), strconv.Quote(l.To))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteText writes given links one per line, with position in file they were found in.
+func (g *Graph) WriteText(w io.Writer, links []Edge) error {
+	b := strings.Builder{}
+	for _, l := range g.jsonGraph(links).Links {
+		lines := make([]string, 0, len(l.Lines))
+		for _, n := range l.Lines {
+			lines = append(lines, strconv.Itoa(n))
+		}
+		fmt.Fprintf(&b, "%v:%v: %v\n", l.From, strings.Join(lines, ","), l.Destination)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// IsImageFile returns true if given file path has image extension.
+func IsImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".ico", ".bmp":
+		return true
+	}
+	return false
+}
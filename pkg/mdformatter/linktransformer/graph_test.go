@@ -0,0 +1,148 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/kit/log"
+)
+
+func TestGraph_Orphans(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-graph")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	files := map[string]string{
+		"README.md":            "# Readme\n\n[1](docs/a.md#a) [2](/docs/guide) [3](https://example.com/orphan.md)\n",
+		"docs/a.md":            "# A\n\n![1](img/used.png) <img src=\"img/html.png\"> [2](#a) [3](.) ![4](img/raw.png?raw=true)\n",
+		"docs/orphan.md":       "# Orphan\n\n[1](a.md) [2](orphan.md)\n",
+		"docs/guide/_index.md": "# Guide\n",
+		"docs/img/used.png":    "",
+		"docs/img/html.png":    "",
+		"docs/img/unused.png":  "",
+		"docs/img/raw.png":     "",
+	}
+	var all, docs []string
+	for f, content := range files {
+		testutil.Ok(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, f)), os.ModePerm))
+		testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, f), []byte(content), os.ModePerm))
+		all = append(all, filepath.Join(tmpDir, f))
+		if IsMarkdownFile(f) {
+			docs = append(docs, filepath.Join(tmpDir, f))
+		}
+	}
+
+	g := NewGraph(tmpDir)
+	_, err = mdformatter.IsFormatted(context.TODO(), log.NewNopLogger(), docs, mdformatter.WithLinkTransformer(g))
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, []string{
+		filepath.Join(tmpDir, "README.md"),
+		filepath.Join(tmpDir, "docs", "img", "unused.png"),
+		filepath.Join(tmpDir, "docs", "orphan.md"),
+	}, g.Orphans(all, func(string) bool { return false }))
+	testutil.Equals(t, []string{
+		filepath.Join(tmpDir, "docs", "img", "unused.png"),
+		filepath.Join(tmpDir, "docs", "orphan.md"),
+	}, g.Orphans(all, func(f string) bool { return filepath.Base(f) == "README.md" }))
+}
+
+// newTestGraph returns graph of links between test files.
+func newTestGraph(t *testing.T) (string, *Graph) {
+	tmpDir, err := ioutil.TempDir("", "test-graph")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	var docs []string
+	for _, f := range []struct{ path, content string }{
+		{path: "README.md", content: "# Readme\n\n[1](docs/a.md#a) [2](/docs/guide) [3](https://example.com/orphan.md#x)\n"},
+		{path: "docs/a.md", content: "# A\n\n![1](img/used.png) <img src=\"img/html.png\"> [2](#a) [3](a.md)\n\n[4](missing.md)\n"},
+		{path: "docs/orphan.md", content: "# Orphan\n\n[1](a.md) [2](orphan.md)\n"},
+		{path: "docs/guide/_index.md", content: "# Guide\n"},
+		{path: "docs/img/used.png"},
+		{path: "docs/img/html.png"},
+	} {
+		path := filepath.Join(tmpDir, f.path)
+		testutil.Ok(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		testutil.Ok(t, ioutil.WriteFile(path, []byte(f.content), os.ModePerm))
+		if IsMarkdownFile(path) {
+			docs = append(docs, path)
+		}
+	}
+
+	g := NewGraph(tmpDir)
+	_, err = mdformatter.IsFormatted(context.TODO(), log.NewNopLogger(), docs, mdformatter.WithLinkTransformer(g))
+	testutil.Ok(t, err)
+	return tmpDir, g
+}
+
+func TestGraph_Write(t *testing.T) {
+	tmpDir, g := newTestGraph(t)
+
+	t.Run("dot", func(t *testing.T) {
+		b := bytes.Buffer{}
+		testutil.Ok(t, g.WriteDOT(&b, g.Edges()))
+		testutil.Equals(t, `digraph mdox {
+  "README.md" [shape=box];
+  "docs/a.md" [shape=box];
+  "docs/guide" [shape=folder];
+  "https://example.com/orphan.md" [shape=ellipse, color=gray];
+  "docs/img/used.png" [shape=box];
+  "docs/img/html.png" [shape=box];
+  "docs/missing.md" [shape=box, color=red];
+  "docs/orphan.md" [shape=box];
+  "README.md" -> "docs/a.md";
+  "README.md" -> "docs/guide";
+  "README.md" -> "https://example.com/orphan.md";
+  "docs/a.md" -> "docs/img/used.png";
+  "docs/a.md" -> "docs/img/html.png";
+  "docs/a.md" -> "docs/a.md";
+  "docs/a.md" -> "docs/missing.md";
+  "docs/orphan.md" -> "docs/a.md";
+  "docs/orphan.md" -> "docs/orphan.md";
+}
+`, b.String())
+	})
+	t.Run("json backlinks", func(t *testing.T) {
+		b := bytes.Buffer{}
+		testutil.Ok(t, g.WriteJSON(&b, g.Backlinks(filepath.Join(tmpDir, "docs", "guide", "_index.md"))))
+		testutil.Equals(t, `{
+  "nodes": [
+    {
+      "id": "README.md",
+      "type": "document"
+    },
+    {
+      "id": "docs/guide",
+      "type": "directory"
+    }
+  ],
+  "links": [
+    {
+      "from": "README.md",
+      "to": "docs/guide",
+      "lines": [
+        3
+      ],
+      "destination": "/docs/guide"
+    }
+  ]
+}
+`, b.String())
+	})
+	t.Run("text backlinks", func(t *testing.T) {
+		b := bytes.Buffer{}
+		testutil.Ok(t, g.WriteText(&b, g.Backlinks(filepath.Join(tmpDir, "docs", "a.md"))))
+		// Links of file to itself are not backlinks.
+		testutil.Equals(t, "README.md:3: docs/a.md#a\ndocs/orphan.md:3: a.md\n", b.String())
+	})
+}
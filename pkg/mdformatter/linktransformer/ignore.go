@@ -0,0 +1,170 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gohugoio/hugo/parser/pageparser"
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// ignoreFrontMatterKey is a front matter key with list of regexes matching links, which are not validated in the file.
+const ignoreFrontMatterKey = "mdox-links-ignore"
+
+// ignoreNextLineRe matches HTML comment marking that links in the next not empty line are not validated.
+var ignoreNextLineRe = regexp.MustCompile(`<!--\s*mdox-links-ignore-next-line([\s:][^>]*)?-->`)
+
+// fileIgnores contains link validation suppressions of single file.
+type fileIgnores struct {
+	markers  []*ignoreMarker
+	patterns []*ignorePattern
+	err      error
+}
+
+type ignoreMarker struct {
+	// line of marker and target line with ignored links, both in file.
+	line, target int
+	used         bool
+}
+
+type ignorePattern struct {
+	re   *regexp.Regexp
+	used bool
+}
+
+// parseIgnores returns suppressions from front matter and inline markers of given markdown file. Markers in
+// code blocks are not taken into account.
+func parseIgnores(file string) *fileIgnores {
+	ig := &fileIgnores{}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		ig.err = errors.Wrapf(err, "read %v", file)
+		return ig
+	}
+
+	content := b
+	fmLines := 0
+	if fm, err := pageparser.ParseFrontMatterAndContent(bytes.NewReader(b)); err == nil && len(fm.FrontMatter) > 0 {
+		content = fm.Content
+		if bytes.HasSuffix(b, content) {
+			fmLines = bytes.Count(b[:len(b)-len(content)], []byte("\n"))
+		}
+		if ig.patterns, err = ignorePatterns(fm.FrontMatter[ignoreFrontMatterKey]); err != nil {
+			ig.err = errors.Wrapf(err, "%v: %v front matter key", file, ignoreFrontMatterKey)
+			return ig
+		}
+	}
+
+	lines := bytes.Split(content, []byte("\n"))
+	addMarkers := func(s text.Segment) {
+		for _, m := range ignoreNextLineRe.FindAllIndex(s.Value(content), -1) {
+			marker := &ignoreMarker{line: bytes.Count(content[:s.Start+m[0]], []byte("\n")) + 1 + fmLines}
+			// Target is the first not empty line after the marker ends.
+			for i := bytes.Count(content[:s.Start+m[1]], []byte("\n")) + 1; i < len(lines); i++ {
+				if len(bytes.TrimSpace(lines[i])) > 0 {
+					marker.target = i + 1 + fmLines
+					break
+				}
+			}
+			ig.markers = append(ig.markers, marker)
+		}
+	}
+
+	doc := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(parser.WithAttribute(), parser.WithHeadingAttribute()),
+	).Parser().Parse(text.NewReader(content))
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch typedNode := n.(type) {
+		case *ast.HTMLBlock:
+			for i := 0; i < typedNode.Lines().Len(); i++ {
+				addMarkers(typedNode.Lines().At(i))
+			}
+			if typedNode.HasClosure() {
+				addMarkers(typedNode.ClosureLine)
+			}
+		case *ast.RawHTML:
+			for i := 0; i < typedNode.Segments.Len(); i++ {
+				addMarkers(typedNode.Segments.At(i))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return ig
+}
+
+// ignorePatterns compiles regexes from front matter value, which can be a single string or list of strings.
+func ignorePatterns(v interface{}) ([]*ignorePattern, error) {
+	var res []string
+	switch typed := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		res = []string{typed}
+	case []interface{}:
+		for _, r := range typed {
+			s, ok := r.(string)
+			if !ok {
+				return nil, errors.Errorf("expected list of regexes, got %v", r)
+			}
+			res = append(res, s)
+		}
+	default:
+		return nil, errors.Errorf("expected list of regexes, got %v", typed)
+	}
+
+	patterns := make([]*ignorePattern, 0, len(res))
+	for _, r := range res {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, &ignorePattern{re: re})
+	}
+	return patterns, nil
+}
+
+// ignored returns true if link is matched by front matter pattern or if all lines with link are marked to be
+// ignored. Matching suppressions are marked as used.
+func (ig *fileIgnores) ignored(dest string, lineNumbers string) bool {
+	for _, p := range ig.patterns {
+		if p.re.MatchString(dest) {
+			p.used = true
+			return true
+		}
+	}
+	if lineNumbers == "" {
+		return false
+	}
+
+	all := true
+	for _, l := range strings.Split(lineNumbers, ",") {
+		n, err := strconv.Atoi(l)
+		if err != nil {
+			return false
+		}
+		found := false
+		for _, m := range ig.markers {
+			if m.target == n {
+				m.used = true
+				found = true
+			}
+		}
+		all = all && found
+	}
+	return all
+}
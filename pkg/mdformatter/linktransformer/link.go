@@ -4,10 +4,9 @@
 package linktransformer
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"io"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,6 +18,7 @@ import (
 	"time"
 
 	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/bwplotka/mdox/pkg/mdformatter/slug"
 	"github.com/efficientgo/tools/core/pkg/merrors"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -31,8 +31,10 @@ var remoteLinkPrefixRe = regexp.MustCompile(`^http[s]?://`)
 type LookupError error
 
 var (
-	FileNotFoundErr = LookupError(errors.New("file not found"))
-	IDNotFoundErr   = LookupError(errors.New("file exists, but does not have such id"))
+	FileNotFoundErr  = LookupError(errors.New("file not found"))
+	IDNotFoundErr    = LookupError(errors.New("file exists, but does not have such id"))
+	CaseMismatchErr  = LookupError(errors.New("file exists, but with different case; it won't be found on case-sensitive file systems"))
+	SymlinkEscapeErr = LookupError(errors.New("file is a symlink or is in symlinked directory pointing outside of anchor dir"))
 )
 
 const (
@@ -40,6 +42,65 @@ const (
 	numberOfRetriesKey = "retryKey"
 )
 
+// headNotSupportedStatusCodes are status codes of responses to HEAD requests, for which link is checked again with GET
+// request, as server does not support HEAD method.
+var headNotSupportedStatusCodes = map[int]struct{}{
+	http.StatusMethodNotAllowed: {},
+	http.StatusNotImplemented:   {},
+}
+
+type options struct {
+	headingIDStyle        slug.Style
+	fixPermanentRedirects bool
+	failOn                Severity
+	rawBaseURL            string
+}
+
+// Option is a functional option type for link transformers.
+type Option func(*options)
+
+// WithHeadingIDStyle sets algorithm used to generate IDs of headings, which local links with fragment
+// (e.g `doc.md#heading`) are checked against. Default is slug.GitHub.
+func WithHeadingIDStyle(style slug.Style) Option {
+	return func(o *options) {
+		o.headingIDStyle = style
+	}
+}
+
+// WithFixPermanentRedirects makes link validator rewrite remote links that are permanently redirected (301 or 308)
+// to the URL they are redirected to. Files have to be transformed twice: the first pass validates links, as usual,
+// and the second one, after Close of file, rewrites them.
+func WithFixPermanentRedirects() Option {
+	return func(o *options) {
+		o.fixPermanentRedirects = true
+	}
+}
+
+// WithFailOn sets the least severity of invalid links that fail validation. Invalid links with lower severity are
+// only logged. Default is SeverityError.
+func WithFailOn(severity Severity) Option {
+	return func(o *options) {
+		o.failOn = severity
+	}
+}
+
+// WithRawBaseURL sets base URL that globalizer joins with paths of files other than markdown ones (e.g images),
+// e.g `https://github.com/org/repo/raw/v0.1.0/`, so links point to content of files and not to pages rendering them.
+// Default is base URL of globalizer.
+func WithRawBaseURL(rawBaseURL string) Option {
+	return func(o *options) {
+		o.rawBaseURL = rawBaseURL
+	}
+}
+
+func applyOptions(opts []Option) options {
+	o := options{headingIDStyle: slug.GitHub, failOn: SeverityError}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 type chain struct {
 	chain []mdformatter.LinkTransformer
 }
@@ -58,6 +119,20 @@ func (l *chain) TransformDestination(ctx mdformatter.SourceContext, destination
 	return destination, nil
 }
 
+// TransformLink transforms link with all transformers in chain, so structured ones can modify whole link. Transformers
+// after the one that removed the link are not called.
+func (l *chain) TransformLink(ctx mdformatter.SourceContext, link *mdformatter.Link) error {
+	for _, c := range l.chain {
+		if err := mdformatter.TransformLink(ctx, c, link); err != nil {
+			return err
+		}
+		if link.Remove {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (l *chain) Close(ctx mdformatter.SourceContext) error {
 	errs := merrors.New()
 	for _, c := range l.chain {
@@ -76,8 +151,9 @@ type localizer struct {
 }
 
 // NewLocalizer returns mdformatter.LinkTransformer that transforms links that matches address via given regexp to local markdown file path (if exists).
-func NewLocalizer(logger log.Logger, address *regexp.Regexp, anchorDir string) mdformatter.LinkTransformer {
-	return &localizer{logger: logger, address: address, anchorDir: anchorDir, localLinksByFile: map[string]*[]string{}}
+func NewLocalizer(logger log.Logger, address *regexp.Regexp, anchorDir string, opts ...Option) mdformatter.LinkTransformer {
+	o := applyOptions(opts)
+	return &localizer{logger: logger, address: address, anchorDir: anchorDir, localLinksByFile: newLocalLinksCache(anchorDir, o.headingIDStyle)}
 }
 
 func (l *localizer) TransformDestination(ctx mdformatter.SourceContext, destination []byte) (_ []byte, err error) {
@@ -114,18 +190,101 @@ func (l *localizer) TransformDestination(ctx mdformatter.SourceContext, destinat
 
 func (l *localizer) Close(mdformatter.SourceContext) error { return nil }
 
+type globalizer struct {
+	baseURL    string
+	rawBaseURL string
+	anchorDir  string
+
+	localLinksByFile localLinksCache
+
+	logger log.Logger
+}
+
+// NewGlobalizer returns mdformatter.LinkTransformer that transforms relative and absolute path links to existing local
+// files into absolute URLs, by joining given base URL with path relative to anchor dir. It's the inverse of localizer,
+// useful for publishing markdown where relative links do not work (e.g pkg.go.dev or release notes). Links to files
+// other than markdown ones use base URL from WithRawBaseURL, if given.
+func NewGlobalizer(logger log.Logger, baseURL string, anchorDir string, opts ...Option) mdformatter.LinkTransformer {
+	o := applyOptions(opts)
+	if o.rawBaseURL == "" {
+		o.rawBaseURL = baseURL
+	}
+	return &globalizer{
+		logger:           logger,
+		baseURL:          strings.TrimSuffix(baseURL, "/"),
+		rawBaseURL:       strings.TrimSuffix(o.rawBaseURL, "/"),
+		anchorDir:        anchorDir,
+		localLinksByFile: newLocalLinksCache(anchorDir, o.headingIDStyle),
+	}
+}
+
+func (g *globalizer) TransformDestination(ctx mdformatter.SourceContext, destination []byte) (_ []byte, err error) {
+	dest := string(destination)
+	if schemeRe.MatchString(dest) || strings.HasPrefix(dest, "#") {
+		// Remote links and links within the same document work everywhere.
+		return destination, nil
+	}
+
+	newDest := absLocalLink(g.anchorDir, ctx.Filepath, dest)
+	if err := g.localLinksByFile.Lookup(newDest); err != nil {
+		level.Debug(g.logger).Log("msg", "attempted globalization failed, no such local link; skipping", "err", err)
+		return destination, nil
+	}
+
+	split := splitLocalLink(newDest)
+	rel, err := filepath.Rel(g.anchorDir, split[0])
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(split[0])
+	isDir := err == nil && st.IsDir()
+	url := g.baseURL
+	if !isDir && !IsMarkdownFile(split[0]) {
+		url = g.rawBaseURL
+	}
+	if rel != "." {
+		url += "/" + filepath.ToSlash(rel)
+	}
+	if isDir && strings.HasSuffix(strings.Split(dest, "#")[0], "/") {
+		url += "/"
+	}
+	if len(split) > 1 {
+		url += "#" + split[1]
+	}
+	return []byte(url), nil
+}
+
+func (g *globalizer) Close(mdformatter.SourceContext) error { return nil }
+
 type validator struct {
 	logger         log.Logger
 	anchorDir      string
 	validateConfig Config
+	fixRedirects   bool
+	failOn         Severity
 
 	localLinks  localLinksCache
 	rMu         sync.RWMutex
 	remoteLinks map[string]error
-	c           *colly.Collector
+	// remoteAnchors contains IDs from remote HTML pages. Nil entry means page is not HTML.
+	remoteAnchors map[string]*[]string
+	// redirects contains redirect chains of remote links.
+	redirects map[string][]redirect
+	// c is a default collector, used if validator does not specify own HTTP policy.
+	c          *colly.Collector
+	collectors []*colly.Collector
 
 	futureMu    sync.Mutex
 	destFutures map[futureKey]*futureResult
+	// validated contains files already validated, which links are rewritten if fixRedirects is enabled.
+	validated map[string]struct{}
+	// ignores contains link validation suppressions per file.
+	ignores map[string]*fileIgnores
+}
+
+type redirect struct {
+	statusCode int
+	url        string
 }
 
 type futureKey struct {
@@ -136,11 +295,13 @@ type futureResult struct {
 	// function giving result, promised after colly.Wait.
 	resultFn func() error
 	cases    int
+	severity Severity
 }
 
 // NewValidator returns mdformatter.LinkTransformer that crawls all links.
 // TODO(bwplotka): Add optimization and debug modes - this is the main source of latency and pain.
-func NewValidator(ctx context.Context, logger log.Logger, linksValidateConfig []byte, anchorDir string) (mdformatter.LinkTransformer, error) {
+func NewValidator(ctx context.Context, logger log.Logger, linksValidateConfig []byte, anchorDir string, opts ...Option) (mdformatter.LinkTransformer, error) {
+	o := applyOptions(opts)
 	var err error
 	config := Config{}
 	if string(linksValidateConfig) != "" {
@@ -153,80 +314,174 @@ func NewValidator(ctx context.Context, logger log.Logger, linksValidateConfig []
 		logger:         logger,
 		anchorDir:      anchorDir,
 		validateConfig: config,
-		localLinks:     map[string]*[]string{},
+		localLinks:     newLocalLinksCache(anchorDir, o.headingIDStyle),
 		remoteLinks:    map[string]error{},
-		c:              colly.NewCollector(colly.Async(), colly.StdlibContext(ctx)),
+		remoteAnchors:  map[string]*[]string{},
+		redirects:      map[string][]redirect{},
+		fixRedirects:   o.fixPermanentRedirects,
+		failOn:         o.failOn,
 		destFutures:    map[futureKey]*futureResult{},
+		validated:      map[string]struct{}{},
+		ignores:        map[string]*fileIgnores{},
+	}
+	if v.c, err = v.newCollector(ctx, ValidatorConfig{}); err != nil {
+		return nil, err
+	}
+	v.collectors = append(v.collectors, v.c)
+
+	// Round trip validators with custom HTTP policy use their own collectors.
+	for i, vc := range v.validateConfig.Validators {
+		if vc.Type != roundtripValidator || !vc.hasHTTPPolicy() {
+			continue
+		}
+		c, err := v.newCollector(ctx, vc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "validator %v", vc.Regex)
+		}
+		v.validateConfig.Validators[i].rtValidator._c = c
+		v.collectors = append(v.collectors, c)
+	}
+	return v, nil
+}
+
+// newCollector returns colly.Collector that visits remote links following HTTP policy from given config.
+func (v *validator) newCollector(ctx context.Context, cfg ValidatorConfig) (*colly.Collector, error) {
+	c := colly.NewCollector(colly.Async(), colly.StdlibContext(ctx))
+	if cfg.Timeout > 0 {
+		c.SetRequestTimeout(cfg.Timeout)
+	}
+	if cfg.MaxBodySize > 0 {
+		c.MaxBodySize = cfg.MaxBodySize
+	}
+	for _, l := range cfg.RateLimits {
+		if err := c.Limit(&colly.LimitRule{
+			DomainGlob:  l.DomainGlob,
+			Parallelism: l.Parallelism,
+			Delay:       l.Delay,
+			RandomDelay: l.RandomDelay,
+		}); err != nil {
+			return nil, errors.Wrapf(err, "rate limit for %v", l.DomainGlob)
+		}
 	}
 	// Set very soft limits.
 	// E.g github has 50-5000 https://docs.github.com/en/free-pro-team@latest/rest/reference/rate-limit limit depending
 	// on api (only search is below 100).
-	if err := v.c.Limit(&colly.LimitRule{
+	if err := c.Limit(&colly.LimitRule{
 		DomainGlob:  "*",
 		Parallelism: 100,
 	}); err != nil {
 		return nil, err
 	}
-	v.c.OnRequest(func(request *colly.Request) {
+
+	retry := defaultRetryConfig
+	if cfg.Retry != nil {
+		retry = *cfg.Retry
+	}
+	acceptStatusCodes := map[int]struct{}{}
+	for _, code := range cfg.AcceptStatusCodes {
+		acceptStatusCodes[code] = struct{}{}
+	}
+
+	c.OnRequest(func(request *colly.Request) {
+		for k, val := range cfg.Headers {
+			request.Headers.Set(k, val)
+		}
 		v.rMu.Lock()
 		defer v.rMu.Unlock()
 		request.Ctx.Put(originalURLKey, request.URL.String())
 	})
-	v.c.OnScraped(func(response *colly.Response) {
+	c.SetRedirectHandler(func(req *http.Request, via []*http.Request) error {
+		// Honor golangs default of maximum of 10 redirects.
+		if len(via) >= 10 {
+			return http.ErrUseLastResponse
+		}
+		if req.URL.Host != via[len(via)-1].URL.Host {
+			req.Header.Del("Authorization")
+		}
+		// Custom headers are only for URLs matching validator.
+		if len(cfg.Headers) > 0 && !cfg.rtValidator._regex.MatchString(req.URL.String()) {
+			for k := range cfg.Headers {
+				req.Header.Del(k)
+			}
+		}
+
+		v.rMu.Lock()
+		defer v.rMu.Unlock()
+		originalURL := via[0].URL.String()
+		if len(via) == 1 {
+			// Request might be retried, start from scratch.
+			v.redirects[originalURL] = nil
+		}
+		v.redirects[originalURL] = append(v.redirects[originalURL], redirect{statusCode: req.Response.StatusCode, url: req.URL.String()})
+		return nil
+	})
+	c.OnResponse(func(response *colly.Response) {
+		if response.Request.Method == http.MethodHead || !strings.Contains(response.Headers.Get("Content-Type"), "html") {
+			return
+		}
+		anchors := htmlPageAnchors(response.Body)
 		v.rMu.Lock()
 		defer v.rMu.Unlock()
-		v.remoteLinks[response.Ctx.Get(originalURLKey)] = nil
+		v.remoteAnchors[response.Ctx.Get(originalURLKey)] = &anchors
 	})
-	v.c.OnError(func(response *colly.Response, err error) {
+	c.OnScraped(func(response *colly.Response) {
 		v.rMu.Lock()
 		defer v.rMu.Unlock()
-		retriesStr := response.Ctx.Get(numberOfRetriesKey)
-		retries, _ := strconv.Atoi(retriesStr)
-		switch response.StatusCode {
-		case http.StatusTooManyRequests:
-			if retries > 0 {
-				break
-			}
-			var retryAfterSeconds int
-			// Retry calls same methods as Visit and makes request with same options.
-			// So retryKey is incremented here if onError is called again after Retry. By default retries once.
-			response.Ctx.Put(numberOfRetriesKey, strconv.Itoa(retries+1))
-			retryAfterSeconds, convErr := strconv.Atoi(response.Headers.Get("Retry-After"))
-			if convErr != nil {
-				retryAfterSeconds = 1
-			}
-			select {
-			case <-time.After(time.Duration(retryAfterSeconds) * time.Second):
-			case <-v.c.Context.Done():
-				return
-			}
+		v.remoteLinks[response.Ctx.Get(originalURLKey)] = nil
+	})
+	c.OnError(func(response *colly.Response, err error) {
+		originalURL := response.Ctx.Get(originalURLKey)
+		if _, ok := acceptStatusCodes[response.StatusCode]; ok {
+			v.rMu.Lock()
+			defer v.rMu.Unlock()
+			v.remoteLinks[originalURL] = nil
+			return
+		}
 
-			if retryErr := response.Request.Retry(); retryErr != nil {
-				v.remoteLinks[response.Ctx.Get(originalURLKey)] = errors.Wrapf(err, "remote link retry %v", response.Ctx.Get(originalURLKey))
-				break
+		retries, _ := strconv.Atoi(response.Ctx.Get(numberOfRetriesKey))
+		if _, ok := headNotSupportedStatusCodes[response.StatusCode]; ok && response.Request.Method == http.MethodHead {
+			// Server does not support HEAD requests, check with GET request.
+			if getErr := c.Request(http.MethodGet, response.Request.URL.String(), nil, response.Ctx, nil); getErr != nil {
+				v.rMu.Lock()
+				defer v.rMu.Unlock()
+				v.remoteLinks[originalURL] = errors.Wrapf(getErr, "remote link %v", originalURL)
 			}
-			v.remoteLinks[response.Ctx.Get(originalURLKey)] = errors.Wrapf(err, "%q rate limited even after retry; status code %v", response.Request.URL.String(), response.StatusCode)
-		case http.StatusMovedPermanently, http.StatusTemporaryRedirect, http.StatusServiceUnavailable:
+			return
+		}
+		if retries >= retry.Max || !retry.retryable(response.StatusCode) {
+			v.rMu.Lock()
+			defer v.rMu.Unlock()
 			if retries > 0 {
-				break
+				v.remoteLinks[originalURL] = errors.Wrapf(err, "%q not accessible even after %v retries; status code %v", response.Request.URL.String(), retries, response.StatusCode)
+				return
 			}
-			response.Ctx.Put(numberOfRetriesKey, strconv.Itoa(retries+1))
+			v.remoteLinks[originalURL] = errors.Wrapf(err, "%q not accessible; status code %v", response.Request.URL.String(), response.StatusCode)
+			return
+		}
 
-			if retryErr := response.Request.Retry(); retryErr != nil {
-				v.remoteLinks[response.Ctx.Get(originalURLKey)] = errors.Wrapf(err, "remote link retry %v", response.Ctx.Get(originalURLKey))
-				break
-			}
-			v.remoteLinks[response.Ctx.Get(originalURLKey)] = errors.Wrapf(err, "%q not accessible even after retry; status code %v", response.Request.URL.String(), response.StatusCode)
-		default:
-			v.remoteLinks[response.Ctx.Get(originalURLKey)] = errors.Wrapf(err, "%q not accessible; status code %v", response.Request.URL.String(), response.StatusCode)
+		// Retry calls same methods as Visit and makes request with same options.
+		// So retryKey is incremented here if onError is called again after Retry.
+		response.Ctx.Put(numberOfRetriesKey, strconv.Itoa(retries+1))
+		select {
+		case <-time.After(retry.backoff(retries, response)):
+		case <-c.Context.Done():
+			v.rMu.Lock()
+			defer v.rMu.Unlock()
+			v.remoteLinks[originalURL] = errors.Wrapf(err, "%q not accessible, retry canceled; status code %v", response.Request.URL.String(), response.StatusCode)
+			return
+		}
+		if retryErr := response.Request.Retry(); retryErr != nil {
+			v.rMu.Lock()
+			defer v.rMu.Unlock()
+			v.remoteLinks[originalURL] = errors.Wrapf(err, "remote link retry %v", originalURL)
 		}
 	})
-	return v, nil
+	return c, nil
 }
 
 // MustNewValidator returns mdformatter.LinkTransformer that crawls all links.
-func MustNewValidator(logger log.Logger, linksValidateConfig []byte, anchorDir string) mdformatter.LinkTransformer {
-	v, err := NewValidator(context.TODO(), logger, linksValidateConfig, anchorDir)
+func MustNewValidator(logger log.Logger, linksValidateConfig []byte, anchorDir string, opts ...Option) mdformatter.LinkTransformer {
+	v, err := NewValidator(context.TODO(), logger, linksValidateConfig, anchorDir, opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -234,12 +489,77 @@ func MustNewValidator(logger log.Logger, linksValidateConfig []byte, anchorDir s
 }
 
 func (v *validator) TransformDestination(ctx mdformatter.SourceContext, destination []byte) (_ []byte, err error) {
-	v.visit(ctx.Filepath, string(destination), ctx.LineNumbers)
-	return destination, nil
+	if !v.isValidated(ctx.Filepath) {
+		v.visit(ctx.Filepath, string(destination), ctx.LineNumbers)
+		return destination, nil
+	}
+	if !remoteLinkPrefixRe.Match(destination) {
+		return destination, nil
+	}
+
+	// Results of all links of file are known after its first pass, so it can be rewritten now.
+	url, fragment := splitFragment(string(destination))
+	to, ok := v.permanentRedirect(url)
+	if !ok {
+		return destination, nil
+	}
+	if fragment != "" && !strings.Contains(to, "#") {
+		to += "#" + fragment
+	}
+	path := ctx.Filepath
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, ctx.Filepath); err == nil {
+			path = rel
+		}
+	}
+	level.Info(v.logger).Log("msg", "fixing permanently redirected link", "pos", path+":"+ctx.LineNumbers, "link", string(destination), "to", to)
+	return []byte(to), nil
+}
+
+// isValidated returns true if given file was already validated and its links should be rewritten instead.
+func (v *validator) isValidated(file string) bool {
+	v.futureMu.Lock()
+	defer v.futureMu.Unlock()
+	_, ok := v.validated[file]
+	return ok
+}
+
+func (v *validator) wait() {
+	for _, c := range v.collectors {
+		c.Wait()
+	}
+}
+
+// permanentRedirect returns URL that given remote link is redirected to with permanent (301 or 308) redirects only.
+func (v *validator) permanentRedirect(url string) (string, bool) {
+	v.rMu.RLock()
+	defer v.rMu.RUnlock()
+	if err := v.remoteLinks[url]; err != nil {
+		return "", false
+	}
+
+	to := ""
+	for _, r := range v.redirects[url] {
+		if r.statusCode != http.StatusMovedPermanently && r.statusCode != http.StatusPermanentRedirect {
+			break
+		}
+		to = r.url
+	}
+	return to, to != ""
 }
 
 func (v *validator) Close(ctx mdformatter.SourceContext) error {
-	v.c.Wait()
+	if v.isValidated(ctx.Filepath) {
+		return nil
+	}
+	if v.fixRedirects {
+		defer func() {
+			v.futureMu.Lock()
+			defer v.futureMu.Unlock()
+			v.validated[ctx.Filepath] = struct{}{}
+		}()
+	}
+	v.wait()
 
 	var keys []futureKey
 	for k := range v.destFutures {
@@ -264,17 +584,57 @@ func (v *validator) Close(ctx mdformatter.SourceContext) error {
 
 	for _, k := range keys {
 		f := v.destFutures[k]
-		if err := f.resultFn(); err != nil {
-			if f.cases == 1 {
-				merr.Add(errors.Wrapf(err, "%v:%v", path, k.lineNumbers))
-				continue
+		if !v.fixRedirects && remoteLinkPrefixRe.MatchString(k.dest) {
+			url, _ := splitFragment(k.dest)
+			if to, ok := v.permanentRedirect(url); ok {
+				level.Warn(v.logger).Log("msg", "link is permanently redirected, consider updating it", "pos", path+":"+k.lineNumbers, "link", k.dest, "to", to)
 			}
-			merr.Add(errors.Wrapf(err, "%v:%v (%v occurrences)", path, k.lineNumbers, f.cases))
+		}
+		err := f.resultFn()
+		if err == nil {
+			continue
+		}
+		pos := path + ":" + k.lineNumbers
+		if f.cases > 1 {
+			pos += fmt.Sprintf(" (%v occurrences)", f.cases)
+		}
+		switch {
+		case f.severity.AtLeast(v.failOn):
+			merr.Add(errors.Wrapf(err, "%v", pos))
+		case f.severity == SeverityWarn:
+			level.Warn(v.logger).Log("msg", "invalid link", "pos", pos, "err", err)
+		default:
+			level.Info(v.logger).Log("msg", "invalid link", "pos", pos, "err", err)
+		}
+	}
+
+	v.futureMu.Lock()
+	ig := v.fileIgnores(ctx.Filepath)
+	v.futureMu.Unlock()
+	merr.Add(ig.err)
+	for _, m := range ig.markers {
+		if !m.used {
+			level.Warn(v.logger).Log("msg", "unused link validation ignore marker, consider removing it", "pos", path+":"+strconv.Itoa(m.line))
+		}
+	}
+	for _, p := range ig.patterns {
+		if !p.used {
+			level.Warn(v.logger).Log("msg", "unused link validation ignore pattern, consider removing it", "pos", path, "key", ignoreFrontMatterKey, "pattern", p.re.String())
 		}
 	}
 	return merr.Err()
 }
 
+// fileIgnores returns link validation suppressions of given file, parsing it on first use. futureMu has to be held.
+func (v *validator) fileIgnores(file string) *fileIgnores {
+	ig, ok := v.ignores[file]
+	if !ok {
+		ig = parseIgnores(file)
+		v.ignores[file] = ig
+	}
+	return ig
+}
+
 func (v *validator) visit(filepath string, dest string, lineNumbers string) {
 	v.futureMu.Lock()
 	defer v.futureMu.Unlock()
@@ -283,7 +643,11 @@ func (v *validator) visit(filepath string, dest string, lineNumbers string) {
 		v.destFutures[k].cases++
 		return
 	}
-	v.destFutures[k] = &futureResult{cases: 1, resultFn: func() error { return nil }}
+	// Local links are always errors.
+	v.destFutures[k] = &futureResult{cases: 1, resultFn: func() error { return nil }, severity: SeverityError}
+	if v.fileIgnores(filepath).ignored(dest, lineNumbers) {
+		return
+	}
 	matches := remoteLinkPrefixRe.FindAllStringIndex(dest, 1)
 	if matches == nil {
 		// Relative or absolute path. Check if exists.
@@ -295,6 +659,7 @@ func (v *validator) visit(filepath string, dest string, lineNumbers string) {
 		}
 		return
 	}
+	v.destFutures[k].severity = v.validateConfig.GetSeverityForURL(dest)
 	validator := v.validateConfig.GetValidatorForURL(dest)
 	if validator != nil {
 		matched, err := validator.IsValid(k, v)
@@ -304,22 +669,46 @@ func (v *validator) visit(filepath string, dest string, lineNumbers string) {
 	}
 }
 
-type localLinksCache map[string]*[]string
+type localLinksCache struct {
+	anchorDir string
+	// realAnchorDir is anchorDir with symlinks resolved.
+	realAnchorDir  string
+	headingIDStyle slug.Style
+
+	files map[string]*[]string
+	// pathErrs contains lookup errors of existing files, e.g CaseMismatchErr.
+	pathErrs map[string]error
+	// dirEntries contains names of directory entries.
+	dirEntries map[string][]string
+}
 
-// Lookup looks for given link in local anchorDir. It returns error if link can't be found.
-func (l localLinksCache) Lookup(absLink string) error {
-	splitWith := "#"
-	if strings.Contains(absLink, "/#") {
-		splitWith = "/#"
+func newLocalLinksCache(anchorDir string, headingIDStyle slug.Style) localLinksCache {
+	realAnchorDir, err := filepath.EvalSymlinks(anchorDir)
+	if err != nil {
+		realAnchorDir = anchorDir
+	}
+	return localLinksCache{
+		anchorDir:      anchorDir,
+		realAnchorDir:  realAnchorDir,
+		headingIDStyle: headingIDStyle,
+		files:          map[string]*[]string{},
+		pathErrs:       map[string]error{},
+		dirEntries:     map[string][]string{},
 	}
+}
 
-	absLinkSplit := strings.Split(absLink, splitWith)
-	ids, ok := l[absLinkSplit[0]]
+// Lookup looks for given link in local anchorDir. It returns error if link can't be found.
+func (l localLinksCache) Lookup(absLink string) error {
+	absLinkSplit := splitLocalLink(absLink)
+	ids, ok := l.files[absLinkSplit[0]]
 	if !ok {
 		if err := l.addRelLinks(absLinkSplit[0]); err != nil {
 			return err
 		}
-		ids = l[absLinkSplit[0]]
+		ids = l.files[absLinkSplit[0]]
+	}
+	if err := l.pathErrs[absLinkSplit[0]]; err != nil {
+		return err
 	}
 	if ids == nil {
 		return errors.Wrapf(FileNotFoundErr, "%v", absLinkSplit[0])
@@ -337,9 +726,32 @@ func (l localLinksCache) Lookup(absLink string) error {
 	return errors.Wrapf(IDNotFoundErr, "link %v, existing ids: %v", absLink, *ids)
 }
 
+// splitLocalLink splits absolute local link into path and, if present, ID e.g `/docs/doc.md#heading`.
+func splitLocalLink(absLink string) []string {
+	splitWith := "#"
+	if strings.Contains(absLink, "/#") {
+		splitWith = "/#"
+	}
+	return strings.Split(absLink, splitWith)
+}
+
+// splitQuery splits local path into path without query and query, e.g `img.png?raw=true` into `img.png` and
+// `?raw=true`.
+func splitQuery(path string) (string, string) {
+	if i := strings.Index(path, "?"); i >= 0 {
+		return path[:i], path[i:]
+	}
+	return path, ""
+}
+
 func (l localLinksCache) addRelLinks(localLink string) error {
 	// Add item for negative caching.
-	l[localLink] = nil
+	l.files[localLink] = nil
+
+	if err := l.checkCase(localLink); err != nil {
+		l.pathErrs[localLink] = err
+		return nil
+	}
 
 	st, err := os.Stat(localLink)
 	if err != nil {
@@ -349,65 +761,91 @@ func (l localLinksCache) addRelLinks(localLink string) error {
 		return errors.Wrapf(err, "failed to stat %v", localLink)
 	}
 
+	if isWithinDir(l.anchorDir, localLink) {
+		realLink, err := filepath.EvalSymlinks(localLink)
+		if err != nil {
+			return errors.Wrapf(err, "failed to resolve symlinks of %v", localLink)
+		}
+		if !isWithinDir(l.realAnchorDir, realLink) {
+			l.pathErrs[localLink] = errors.Wrapf(SymlinkEscapeErr, "%v (resolved to %v)", localLink, realLink)
+			return nil
+		}
+	}
+
 	if st.IsDir() {
 		// Dir present, cache presence.
 		ids := make([]string, 0)
-		l[localLink] = &ids
+		l.files[localLink] = &ids
 		return nil
 	}
 
-	file, err := os.Open(localLink)
-	if err != nil {
-		return errors.Wrapf(err, "failed to open file %v", localLink)
-	}
-	defer file.Close()
-
 	// File present, cache presence.
 	ids := make([]string, 0)
-
-	var b []byte
-	reader := bufio.NewReader(file)
-	for {
-		b, err = reader.ReadBytes('\n')
+	if IsMarkdownFile(localLink) {
+		b, err := ioutil.ReadFile(localLink)
 		if err != nil {
-			if err != io.EOF {
-				return errors.Wrapf(err, "failed to read file %v", localLink)
+			return errors.Wrapf(err, "failed to read file %v", localLink)
+		}
+		if ids, err = markdownAnchors(b, l.headingIDStyle); err != nil {
+			return errors.Wrapf(err, "failed to parse file %v", localLink)
+		}
+	}
+	l.files[localLink] = &ids
+	return nil
+}
+
+// checkCase returns CaseMismatchErr if path segment under anchor dir of given path matches directory entry only
+// if case is ignored. Case-insensitive file systems (e.g on macOS) would resolve such path, other would not.
+func (l localLinksCache) checkCase(localLink string) error {
+	if !isWithinDir(l.anchorDir, localLink) {
+		return nil
+	}
+	rel, err := filepath.Rel(l.anchorDir, localLink)
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	dir := l.anchorDir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		names, ok := l.dirEntries[dir]
+		if !ok {
+			// Not readable directory is reported when file is accessed.
+			infos, _ := ioutil.ReadDir(dir)
+			for _, info := range infos {
+				names = append(names, info.Name())
 			}
-			break
+			l.dirEntries[dir] = names
 		}
 
-		if bytes.HasPrefix(b, []byte(`#`)) {
-			ids = append(ids, toHeaderID(b))
+		onDisk := ""
+		for _, n := range names {
+			if n == name {
+				onDisk = n
+				break
+			}
+			if strings.EqualFold(n, name) {
+				onDisk = n
+			}
 		}
+		if onDisk == "" {
+			// Not found, stat will tell.
+			return nil
+		}
+		if onDisk != name {
+			return errors.Wrapf(CaseMismatchErr, "%v (%v on disk)", localLink, filepath.Join(dir, onDisk))
+		}
+		dir = filepath.Join(dir, name)
 	}
-
-	l[localLink] = &ids
 	return nil
 }
 
-func toHeaderID(header []byte) string {
-	var id []byte
-	// Remove punctuation from header except '-' or '#'.
-	// '\p{L}\p{N}\p{M}' is the unicode equivalent of '\w', https://www.regular-expressions.info/unicode.html.
-	punctuation := regexp.MustCompile(`[^\p{L}\p{N}\p{M}-# ]`)
-	header = punctuation.ReplaceAll(header, []byte(""))
-	headerText := bytes.TrimLeft(bytes.ToLower(header), "#")
-	// If header is just punctuation it comes up empty, so it cannot be linked.
-	if len(headerText) <= 1 {
-		return ""
-	}
-
-	for _, h := range headerText[1:] {
-		switch h {
-		case '{':
-			return string(id)
-		case ' ', '-':
-			id = append(id, '-')
-		default:
-			id = append(id, h)
-		}
+// isWithinDir returns true if given path is dir or is in dir, without resolving symlinks.
+func isWithinDir(dir string, path string) bool {
+	if dir == "" {
+		return false
 	}
-	return string(id)
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
 func absLocalLink(anchorDir string, docPath string, destination string) string {
@@ -4,15 +4,26 @@
 package linktransformer
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/bwplotka/mdox/pkg/mdformatter/slug"
 	"github.com/efficientgo/tools/core/pkg/testutil"
 	"github.com/go-kit/kit/log"
 )
@@ -119,6 +130,54 @@ func TestLocalizer_TransformDestination(t *testing.T) {
 	})
 }
 
+func TestGlobalizer_TransformDestination(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-globalizer")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, os.MkdirAll(filepath.Join(tmpDir, "repo", "docs", "a"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "repo", "docs", "a", "doc.md"), []byte(testDocWithLinks), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "repo", "docs", "doc2.md"), []byte(testDocWithLinks), os.ModePerm))
+
+	logger := log.NewLogfmtLogger(os.Stderr)
+	anchorDir := filepath.Join(tmpDir, "repo", "docs")
+	diff, err := mdformatter.IsFormatted(context.TODO(), logger, []string{filepath.Join(tmpDir, "repo", "docs", "a", "doc.md")}, mdformatter.WithLinkTransformer(
+		NewGlobalizer(logger, "https://github.com/org/repo/blob/v0.15.0/docs/", anchorDir),
+	))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(diff), diff.String())
+	testutil.Equals(t, fmt.Sprintf(`--- %s/repo/docs/a/doc.md
++++ %s/repo/docs/a/doc.md (formatted)
+@@ -0,1 +0,1 @@
+-[1](http://myproject.example.com/not-docs.md) [2](.)
++[1](http://myproject.example.com/not-docs.md) [2](https://github.com/org/repo/blob/v0.15.0/docs/a/doc.md)
+
+ # Yolo
+
+@@ -11,1 +11,1 @@
+
+ [10](http://myproject.example.com/tip/a/does_not_exists_file.md) [11](https://myproject.example.com/tip/a/does_not_exists_file2) [12](http://myproject.example.com/tip/does_not_exists/does_not_exists_dir.md)
+
+-[11](/doc2.md) [12](/a/doc.md#yolo) [13](../doc2.md) [14](../a/../a/../a/../a/doc.md) [15](doc.md) [16](doc2.md/#yolo-2)
++[11](https://github.com/org/repo/blob/v0.15.0/docs/doc2.md) [12](https://github.com/org/repo/blob/v0.15.0/docs/a/doc.md#yolo) [13](https://github.com/org/repo/blob/v0.15.0/docs/doc2.md) [14](https://github.com/org/repo/blob/v0.15.0/docs/a/doc.md) [15](https://github.com/org/repo/blob/v0.15.0/docs/a/doc.md) [16](https://github.com/org/repo/blob/v0.15.0/docs/doc2.md#yolo-2)
+
+`, tmpDir, tmpDir), diff.String())
+
+	t.Run("raw base URL for not markdown files", func(t *testing.T) {
+		testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "repo", "docs", "a", "img.png"), []byte{}, os.ModePerm))
+		testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "repo", "docs", "a", "doc.pdf"), []byte{}, os.ModePerm))
+		testFile := filepath.Join(tmpDir, "repo", "docs", "a", "images.md")
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte("![1](img.png) [2](doc.md) [3](doc.pdf)\n"), os.ModePerm))
+
+		testutil.Ok(t, mdformatter.Format(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			NewGlobalizer(logger, "https://github.com/org/repo/blob/v0.15.0/docs/", anchorDir, WithRawBaseURL("https://github.com/org/repo/raw/v0.15.0/docs/")),
+		)))
+		b, err := ioutil.ReadFile(testFile)
+		testutil.Ok(t, err)
+		testutil.Equals(t, "![1](https://github.com/org/repo/raw/v0.15.0/docs/a/img.png) [2](https://github.com/org/repo/blob/v0.15.0/docs/a/doc.md) [3](https://github.com/org/repo/raw/v0.15.0/docs/a/doc.pdf)\n", string(b))
+	})
+}
+
 func TestValidator_TransformDestination(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "test-validator")
 	testutil.Ok(t, err)
@@ -225,6 +284,85 @@ func TestValidator_TransformDestination(t *testing.T) {
 		testutil.Equals(t, 0, len(diff), diff.String())
 	})
 
+	t.Run("check valid local links with duplicated headings", func(t *testing.T) {
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "valid-local-links-duplicated.md")
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(`# Example
+
+## Example
+
+## Example
+
+[1](#example) [2](#example-1) [3](#example-2)
+`), os.ModePerm))
+
+		diff, err := mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(""), anchorDir),
+		))
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, len(diff), diff.String())
+	})
+
+	t.Run("check local links with hugo heading IDs", func(t *testing.T) {
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "local-links-hugo.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(`# What's new in v0.2?
+
+## Heading with custom ID {#custom}
+
+[1](#what-s-new-in-v0-2) [2](#custom) [3](#whats-new-in-v02)
+`), os.ModePerm))
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(""), anchorDir, WithHeadingIDStyle(slug.Hugo)),
+		))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: %v:5: link #whats-new-in-v02, normalized to: link %v#whats-new-in-v02, existing ids: [what-s-new-in-v0-2 custom]: file exists, but does not have such id",
+			testFile, relPath, testFile), err.Error())
+	})
+
+	t.Run("check local links to anchors of all kinds", func(t *testing.T) {
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "local-links-anchors.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(`---
+title: Front Matter
+---
+
+Setext Heading
+==============
+
+## Heading with custom ID {#custom}
+
+<a name="html-name"></a>
+
+<div id="html-id">
+Text <span id='inline-id'>inline</span>.
+</div>
+
+`+"```"+`bash
+# Not a heading
+`+"```"+`
+
+[1](#setext-heading) [2](#custom) [3](#heading-with-custom-id-custom) [4](#html-name) [5](#html-id) [6](#inline-id)
+
+[7](#not-a-heading) [8](#title-front-matter)
+`), os.ModePerm))
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(""), anchorDir),
+		))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: 2 errors: "+
+			"%v:22: link #title-front-matter, normalized to: link %v#title-front-matter, existing ids: [setext-heading custom heading-with-custom-id-custom html-name html-id inline-id]: file exists, but does not have such id; "+
+			"%v:22: link #not-a-heading, normalized to: link %v#not-a-heading, existing ids: [setext-heading custom heading-with-custom-id-custom html-name html-id inline-id]: file exists, but does not have such id",
+			testFile, relPath, testFile, relPath, testFile), err.Error())
+	})
+
 	t.Run("check invalid local links", func(t *testing.T) {
 		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "invalid-local-links.md")
 		filePath := "/repo/docs/test/invalid-local-links.md"
@@ -254,6 +392,83 @@ func TestValidator_TransformDestination(t *testing.T) {
 			tmpDir+filePath, relDirPath+filePath, tmpDir, relDirPath+filePath, tmpDir, relDirPath+filePath, tmpDir, relDirPath+filePath, tmpDir), err.Error())
 	})
 
+	t.Run("check local links with different case and escaping symlinks", func(t *testing.T) {
+		testutil.Ok(t, os.MkdirAll(filepath.Join(tmpDir, "repo", "docs", "Case"), os.ModePerm))
+		testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "repo", "docs", "Case", "Intro.md"), []byte("# Intro\n"), os.ModePerm))
+		testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "outside.md"), []byte("# Outside\n"), os.ModePerm))
+		testutil.Ok(t, os.Symlink(filepath.Join("..", "..", "..", "outside.md"), filepath.Join(tmpDir, "repo", "docs", "test", "escape.md")))
+		testutil.Ok(t, os.Symlink(filepath.Join("..", "Case"), filepath.Join(tmpDir, "repo", "docs", "test", "inside")))
+		t.Cleanup(func() {
+			// Remove fixtures, so other subtests walking shared test tree do not see them.
+			for _, f := range []string{"repo/docs/test/escape.md", "repo/docs/test/inside", "repo/docs/Case", "outside.md"} {
+				testutil.Ok(t, os.RemoveAll(filepath.Join(tmpDir, filepath.FromSlash(f))))
+			}
+		})
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "case-links.md")
+		filePath := "/repo/docs/test/case-links.md"
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relDirPath, err := filepath.Rel(wdir, tmpDir)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte("[1](../Case/Intro.md#intro) [2](../case/intro.md) [3](../Case/intro.md#intro) [4](escape.md) [5](inside/Intro.md#intro)\n"), os.ModePerm))
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(""), anchorDir),
+		))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: 3 errors: "+
+			"%v:1: link escape.md, normalized to: %v/repo/docs/test/escape.md (resolved to %v/outside.md): file is a symlink or is in symlinked directory pointing outside of anchor dir; "+
+			"%v:1: link ../case/intro.md, normalized to: %v/repo/docs/case/intro.md (%v/repo/docs/Case on disk): file exists, but with different case; it won't be found on case-sensitive file systems; "+
+			"%v:1: link ../Case/intro.md#intro, normalized to: %v/repo/docs/Case/intro.md (%v/repo/docs/Case/Intro.md on disk): file exists, but with different case; it won't be found on case-sensitive file systems",
+			tmpDir+filePath, relDirPath+filePath, tmpDir, tmpDir, relDirPath+filePath, tmpDir, tmpDir, relDirPath+filePath, tmpDir, tmpDir), err.Error())
+	})
+
+	t.Run("check links with ignore markers and front matter", func(t *testing.T) {
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "ignored-links.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(`---
+title: Ignored links
+mdox-links-ignore:
+  - 'does-not-exist\.example\.invalid'
+  - 'unused\.example\.invalid'
+---
+
+# Ignored links
+
+<!-- mdox-links-ignore-next-line -->
+[1](missing.md) [2](#missing)
+
+[3](missing2.md) <!-- mdox-links-ignore-next-line: flaky -->
+
+[4](missing3.md)
+
+<!-- mdox-links-ignore-next-line -->
+No links here.
+
+[5](http://does-not-exist.example.invalid/x) [6](missing.md)
+
+`+"```"+`markdown
+<!-- mdox-links-ignore-next-line -->
+`+"```"+`
+`), os.ModePerm))
+
+		logs := &bytes.Buffer{}
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(log.NewLogfmtLogger(logs), []byte(""), anchorDir),
+		))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: 2 errors: "+
+			"%v:13: link missing2.md, normalized to: %v/repo/docs/test/missing2.md: file not found; "+
+			"%v:11,20 (2 occurrences): link missing.md, normalized to: %v/repo/docs/test/missing.md: file not found",
+			testFile, relPath, tmpDir, relPath, tmpDir), err.Error())
+		testutil.Equals(t, fmt.Sprintf("level=warn msg=\"unused link validation ignore marker, consider removing it\" pos=%[1]v:17\n"+
+			"level=warn msg=\"unused link validation ignore pattern, consider removing it\" pos=%[1]v key=mdox-links-ignore pattern=unused\\.example\\.invalid\n", relPath), logs.String())
+	})
+
 	t.Run("check 404 link", func(t *testing.T) {
 		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "invalid-link.md")
 		testutil.Ok(t, ioutil.WriteFile(testFile, []byte("https://bwplotka.dev/does-not-exists\n"), os.ModePerm))
@@ -317,4 +532,401 @@ func TestValidator_TransformDestination(t *testing.T) {
 		))
 		testutil.Ok(t, err)
 	})
+	t.Run("check remote links with fragments", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/docs":
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				_, _ = w.Write([]byte(`<html><body><h1 id="intro">Intro</h1><a name="legacy"></a><h2 id="café">Café</h2><h2 id="user-content-prefixed">Prefixed</h2><p>Text</p></body></html>`))
+			case "/file.txt":
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = w.Write([]byte(`<h1 id="intro">Intro</h1>`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "remote-fragments.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(fmt.Sprintf("[1](%[1]v/docs#intro) [2](%[1]v/docs#legacy) [3](%[1]v/docs#not-existing) [4](%[1]v/file.txt#whatever) [5](%[1]v/docs) [6](%[1]v/docs#caf%%C3%%A9)\n\n"+
+			"[7](%[1]v/docs#user-content-prefixed) [8](%[1]v/docs#prefixed) [9](%[1]v/docs#user-content-intro)\n", srv.URL)), os.ModePerm))
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte("version: 1\n\nvalidators:\n  - regex: '127\\.0\\.0\\.1'\n    type: 'roundtrip'\n"), anchorDir),
+		))
+		testutil.Ok(t, err)
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte("version: 1\n\nvalidators:\n  - regex: '127\\.0\\.0\\.1'\n    type: 'roundtrip'\n    checkFragments: true\n"), anchorDir),
+		))
+		testutil.NotOk(t, err)
+		// GitHub "user-content-" prefix of IDs is not special for other sites.
+		testutil.Equals(t, fmt.Sprintf("%v: 3 errors: "+
+			"%v:3: \"%v/docs\" exists, but does not have element with \"user-content-intro\" id; "+
+			"%v:3: \"%v/docs\" exists, but does not have element with \"prefixed\" id; "+
+			"%v:1: \"%v/docs\" exists, but does not have element with \"not-existing\" id", testFile, relPath, srv.URL, relPath, srv.URL, relPath, srv.URL), err.Error())
+	})
+	t.Run("check remote links with HTTP policy", func(t *testing.T) {
+		var flakyCalls, slowCalls int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/flaky":
+				// Fails two times in a row for each 3 calls.
+				if atomic.AddInt32(&flakyCalls, 1)%3 != 0 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+			case "/slow":
+				// First call is slower than timeout.
+				if atomic.AddInt32(&slowCalls, 1) == 1 {
+					time.Sleep(500 * time.Millisecond)
+				}
+			case "/forbidden":
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "remote-http-policy.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(fmt.Sprintf("[1](%[1]v/flaky) [2](%[1]v/slow) [3](%[1]v/forbidden)\n", srv.URL)), os.ModePerm))
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(`version: 1
+validators:
+  - regex: '127\.0\.0\.1'
+    type: 'roundtrip'
+    timeout: 100ms
+    retry:
+      max: 2
+      minBackoff: 10ms
+      transportErrors: true
+    rateLimits:
+      - domainGlob: '127.0.0.1*'
+        parallelism: 2
+    acceptStatusCodes: [403]
+`), anchorDir),
+		))
+		testutil.Ok(t, err)
+
+		atomic.StoreInt32(&flakyCalls, 0)
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(""), anchorDir),
+		))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: 2 errors: "+
+			"%v:1: \"%v/forbidden\" not accessible; status code 403: Forbidden; "+
+			"%v:1: \"%v/flaky\" not accessible even after 1 retries; status code 503: Service Unavailable",
+			testFile, relPath, srv.URL, relPath, srv.URL), err.Error())
+	})
+
+	t.Run("check HTTP policy for not roundtrip validator", func(t *testing.T) {
+		_, err := NewValidator(context.TODO(), logger, []byte("version: 1\n\nvalidators:\n  - regex: 'bwplotka'\n    type: 'ignore'\n    timeout: 1s\n"), anchorDir)
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "checkFragments and HTTP policy are supported only by roundtrip validator, got ignore", err.Error())
+	})
+	t.Run("check links with severity", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		t.Cleanup(srv.Close)
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "severity.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(fmt.Sprintf("[1](%[1]v/flaky) [2](%[1]v/info)\n\n[3](missing.md)\n", srv.URL)), os.ModePerm))
+
+		config := []byte("version: 1\n\nvalidators:\n  - regex: 'flaky'\n    type: 'roundtrip'\n    severity: 'warn'\n    retry:\n      max: 0\n" +
+			"  - regex: 'info'\n    type: 'roundtrip'\n    severity: 'info'\n    retry:\n      max: 0\n")
+		localErr := fmt.Sprintf("%v:3: link missing.md, normalized to: %v/repo/docs/test/missing.md: file not found", relPath, tmpDir)
+		t.Run("fail on error", func(t *testing.T) {
+			logs := &bytes.Buffer{}
+			_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+				MustNewValidator(log.NewLogfmtLogger(logs), config, anchorDir),
+			))
+			testutil.NotOk(t, err)
+			testutil.Equals(t, fmt.Sprintf("%v: %v", testFile, localErr), err.Error())
+			testutil.Equals(t, fmt.Sprintf("level=info msg=\"invalid link\" pos=%[1]v:1 err=\"\\\"%[2]v/info\\\" not accessible; status code 404: Not Found\"\n"+
+				"level=warn msg=\"invalid link\" pos=%[1]v:1 err=\"\\\"%[2]v/flaky\\\" not accessible; status code 404: Not Found\"\n", relPath, srv.URL), logs.String())
+		})
+		t.Run("fail on warn", func(t *testing.T) {
+			_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+				MustNewValidator(logger, config, anchorDir, WithFailOn(SeverityWarn)),
+			))
+			testutil.NotOk(t, err)
+			testutil.Equals(t, fmt.Sprintf("%v: 2 errors: %v; %v:1: \"%v/flaky\" not accessible; status code 404: Not Found", testFile, localErr, relPath, srv.URL), err.Error())
+		})
+		t.Run("not supported severity", func(t *testing.T) {
+			_, err := NewValidator(context.TODO(), logger, []byte("version: 1\n\nvalidators:\n  - regex: 'flaky'\n    type: 'roundtrip'\n    severity: 'fatal'\n"), anchorDir)
+			testutil.NotOk(t, err)
+			testutil.Equals(t, "severity \"fatal\" not supported, expected one of [error warn info]", err.Error())
+		})
+	})
+	t.Run("check remote links with custom headers", func(t *testing.T) {
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Token") != "" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		t.Cleanup(other.Close)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Token") != "secret" || r.Header.Get("User-Agent") != "mdox" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.URL.Path == "/redirect" {
+				http.Redirect(w, r, other.URL, http.StatusFound)
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "remote-headers.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(fmt.Sprintf("[1](%[1]v/private) [2](%[1]v/redirect) [3](%[2]v/other)\n", srv.URL, other.URL)), os.ModePerm))
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(fmt.Sprintf(`version: 1
+validators:
+  - regex: '%v'
+    type: 'roundtrip'
+    headers:
+      X-Token: 'secret'
+      User-Agent: 'mdox'
+`, regexp.QuoteMeta(srv.URL))), anchorDir),
+		))
+		testutil.Ok(t, err)
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(""), anchorDir),
+		))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: 2 errors: "+
+			"%v:1: \"%v/redirect\" not accessible; status code 404: Not Found; "+
+			"%v:1: \"%v/private\" not accessible; status code 404: Not Found",
+			testFile, relPath, srv.URL, relPath, srv.URL), err.Error())
+	})
+	t.Run("check remote links with HEAD requests first", func(t *testing.T) {
+		var (
+			mu       sync.Mutex
+			requests []string
+		)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+
+			switch r.URL.Path {
+			case "/file.pdf":
+				_, _ = w.Write(make([]byte, 1024*1024))
+			case "/no-head":
+				if r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+				}
+			case "/docs":
+				w.Header().Set("Content-Type", "text/html")
+				_, _ = w.Write([]byte(`<h1 id="intro">Intro</h1>`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "remote-head.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(fmt.Sprintf("[1](%[1]v/file.pdf) [2](%[1]v/no-head) [3](%[1]v/missing) [4](http://localhost:%[2]v/docs#intro)\n", srv.URL, srv.Listener.Addr().(*net.TCPAddr).Port)), os.ModePerm))
+
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(logger, []byte(`version: 1
+validators:
+  - regex: 'localhost'
+    type: 'roundtrip'
+    checkFragments: true
+  - regex: '127\.0\.0\.1'
+    type: 'roundtrip'
+    headFirst: true
+    maxBodySize: 1024
+`), anchorDir),
+		))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, fmt.Sprintf("%v: %v:1: \"%v/missing\" not accessible; status code 404: Not Found", testFile, relPath, srv.URL), err.Error())
+
+		sort.Strings(requests)
+		testutil.Equals(t, []string{"GET /docs", "GET /no-head", "HEAD /file.pdf", "HEAD /missing", "HEAD /no-head"}, requests)
+	})
+	t.Run("check headFirst with checkFragments", func(t *testing.T) {
+		_, err := NewValidator(context.TODO(), logger, []byte("version: 1\n\nvalidators:\n  - regex: 'localhost'\n    type: 'roundtrip'\n    headFirst: true\n    checkFragments: true\n"), anchorDir)
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "headFirst can't be used with checkFragments, checking fragments needs response bodies", err.Error())
+	})
+	t.Run("check permanently redirected remote links", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/old":
+				http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			case "/older":
+				http.Redirect(w, r, "/old", http.StatusPermanentRedirect)
+			case "/temporary":
+				http.Redirect(w, r, "/new", http.StatusFound)
+			case "/new":
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "remote-redirects.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(fmt.Sprintf("[1](%[1]v/old#section) [2](%[1]v/temporary)\n\n[3](%[1]v/older)\n", srv.URL)), os.ModePerm))
+
+		logs := &bytes.Buffer{}
+		_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+			MustNewValidator(log.NewLogfmtLogger(logs), []byte(""), anchorDir),
+		))
+		testutil.Ok(t, err)
+		testutil.Equals(t, fmt.Sprintf("level=warn msg=\"link is permanently redirected, consider updating it\" pos=%[1]v:3 link=%[2]v/older to=%[2]v/new\n"+
+			"level=warn msg=\"link is permanently redirected, consider updating it\" pos=%[1]v:1 link=%[2]v/old#section to=%[2]v/new\n", relPath, srv.URL), logs.String())
+
+		// The first pass validates links, the second one rewrites them.
+		v := MustNewValidator(logger, []byte(""), anchorDir, WithFixPermanentRedirects())
+		diff, err := mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(v))
+		testutil.Ok(t, err)
+		testutil.Equals(t, 0, len(diff))
+		testutil.Ok(t, mdformatter.Format(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(v)))
+		b, err := ioutil.ReadFile(testFile)
+		testutil.Ok(t, err)
+		testutil.Equals(t, fmt.Sprintf("[1](%[1]v/new#section) [2](%[1]v/temporary)\n\n[3](%[1]v/new)\n", srv.URL), string(b))
+	})
+	t.Run("check github links offline", func(t *testing.T) {
+		repoDir := filepath.Join(tmpDir, "repo")
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"remote", "add", "origin", "git@github.com:bwplotka/mdox.git"},
+			{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "test"},
+		} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = repoDir
+			out, err := cmd.CombinedOutput()
+			testutil.Ok(t, err, string(out))
+		}
+		cmd := exec.Command("git", "rev-parse", "HEAD")
+		cmd.Dir = repoDir
+		sha, err := cmd.Output()
+		testutil.Ok(t, err)
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "github-link-offline.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte(fmt.Sprintf("https://github.com/bwplotka/mdox/issues/23 https://github.com/bwplotka/mdox/pull/32/files https://github.com/bwplotka/mdox/pull/100\n\n"+
+			"https://github.com/bwplotka/mdox/commit/%v https://github.com/bwplotka/mdox/commit/%v#diff https://github.com/bwplotka/mdox/commit/0123456789abcdef\n\n"+
+			"https://github.com/bwplotka/mdox/commit/ https://github.com/bwplotka/mdox/issues/abc\n", strings.TrimSpace(string(sha)), string(sha[:7]))), os.ModePerm))
+
+		t.Run("static max number", func(t *testing.T) {
+			_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+				MustNewValidator(logger, []byte("version: 1\n\nvalidators:\n  - regex: 'bwplotka\\/mdox(-fork)?'\n    type: 'github'\n    maxNumber: 50\n    checkCommits: true\n"), anchorDir),
+			))
+			testutil.NotOk(t, err)
+			testutil.Equals(t, fmt.Sprintf("%v: 4 errors: "+
+				"%v:1: https://github.com/bwplotka/mdox/pull/100: issue or pull request #100 does not exist, the latest one is #50; "+
+				"%v:5: https://github.com/bwplotka/mdox/issues/abc: issue or pull request number not found; "+
+				"%v:3: https://github.com/bwplotka/mdox/commit/0123456789abcdef: commit 0123456789abcdef not found in local git repository (shallow clone?); "+
+				"%v:5: https://github.com/bwplotka/mdox/commit/: commit SHA not found", testFile, relPath, relPath, relPath, relPath), err.Error())
+		})
+		t.Run("commits not checked by default", func(t *testing.T) {
+			_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+				MustNewValidator(logger, []byte("version: 1\n\nvalidators:\n  - regex: 'bwplotka\\/mdox'\n    type: 'github'\n    maxNumber: 50\n  - regex: 'commit'\n    type: 'ignore'\n"), anchorDir),
+			))
+			testutil.NotOk(t, err)
+			testutil.Equals(t, fmt.Sprintf("%v: 2 errors: "+
+				"%v:1: https://github.com/bwplotka/mdox/pull/100: issue or pull request #100 does not exist, the latest one is #50; "+
+				"%v:5: https://github.com/bwplotka/mdox/issues/abc: issue or pull request number not found", testFile, relPath, relPath), err.Error())
+		})
+		t.Run("state file", func(t *testing.T) {
+			stateFile := filepath.Join(tmpDir, "github-state.yaml")
+			testutil.Ok(t, ioutil.WriteFile(stateFile, []byte("bwplotka/mdox: 100\n"), os.ModePerm))
+			_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+				MustNewValidator(logger, []byte("version: 1\n\nvalidators:\n  - regex: 'bwplotka\\/mdox'\n    type: 'github'\n    stateFile: '"+stateFile+"'\n    checkCommits: true\n"), anchorDir),
+			))
+			testutil.NotOk(t, err)
+			testutil.Equals(t, fmt.Sprintf("%v: 3 errors: "+
+				"%v:5: https://github.com/bwplotka/mdox/issues/abc: issue or pull request number not found; "+
+				"%v:3: https://github.com/bwplotka/mdox/commit/0123456789abcdef: commit 0123456789abcdef not found in local git repository (shallow clone?); "+
+				"%v:5: https://github.com/bwplotka/mdox/commit/: commit SHA not found", testFile, relPath, relPath, relPath), err.Error())
+		})
+	})
+	t.Run("check github blob and tree links locally", func(t *testing.T) {
+		repoDir := filepath.Join(tmpDir, "repo")
+		testutil.Ok(t, os.MkdirAll(filepath.Join(repoDir, "pkg"), os.ModePerm))
+		testutil.Ok(t, ioutil.WriteFile(filepath.Join(repoDir, "pkg", "a.go"), []byte("package a\n\nfunc A() {}\n\nfunc B() {}\n"), os.ModePerm))
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"add", "pkg"},
+			{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "test"},
+			{"checkout", "-q", "-B", "main"},
+			{"tag", "v0.1.0"},
+			{"branch", "release/v1"},
+		} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = repoDir
+			out, err := cmd.CombinedOutput()
+			testutil.Ok(t, err, string(out))
+		}
+		// Working tree differs from committed version.
+		testutil.Ok(t, ioutil.WriteFile(filepath.Join(repoDir, "pkg", "a.go"), []byte("package a\n\nfunc A() {}\n"), os.ModePerm))
+
+		testFile := filepath.Join(tmpDir, "repo", "docs", "test", "github-local-link.md")
+		wdir, err := os.Getwd()
+		testutil.Ok(t, err)
+		relPath, err := filepath.Rel(wdir, testFile)
+		testutil.Ok(t, err)
+		testutil.Ok(t, ioutil.WriteFile(testFile, []byte("https://github.com/bwplotka/mdox/blob/main/pkg/a.go#L1-L3 https://github.com/bwplotka/mdox/blob/main/pkg/a.go#L2-L4\n\n"+
+			"https://github.com/bwplotka/mdox/blob/v0.1.0/pkg/a.go?plain=1#L5 https://github.com/bwplotka/mdox/tree/main/pkg https://github.com/bwplotka/mdox/blob/main/pkg/b.go\n\n"+
+			"https://github.com/bwplotka/mdox/blob/main/docs/doc2.md#yolo-2 https://github.com/bwplotka/mdox/blob/main/docs/doc2.md#user-content-yolo https://github.com/bwplotka/mdox/blob/main/docs/doc2.md#nope\n\n"+
+			"https://github.com/bwplotka/mdox/blob/release/v1/pkg/a.go#L5 https://github.com/bwplotka/mdox/blob/v0.1.0/docs/doc2.md https://github.com/bwplotka/mdox/blob/main/pkg/%zz.go\n"), os.ModePerm))
+
+		t.Run("working tree", func(t *testing.T) {
+			_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+				MustNewValidator(logger, []byte("version: 1\n\nvalidators:\n  - regex: 'bwplotka\\/mdox'\n    type: 'githubLocal'\n    ref: 'main'\n"), anchorDir),
+			))
+			testutil.NotOk(t, err)
+			testutil.Equals(t, fmt.Sprintf("%v: 5 errors: "+
+				"%v:7: https://github.com/bwplotka/mdox/blob/v0.1.0/docs/doc2.md: docs/doc2.md not found in local repository at v0.1.0; "+
+				"%v:3: https://github.com/bwplotka/mdox/blob/main/pkg/b.go: pkg/b.go not found in local repository; "+
+				"%v:1: https://github.com/bwplotka/mdox/blob/main/pkg/a.go#L2-L4: line 4 out of range, pkg/a.go has 3 lines in local repository; "+
+				"%v:7: https://github.com/bwplotka/mdox/blob/main/pkg/%%zz.go: invalid URL escape \"%%zz\"; "+
+				"%v:5: https://github.com/bwplotka/mdox/blob/main/docs/doc2.md#nope: docs/doc2.md exists, but does not have element with \"nope\" id in local repository", testFile, relPath, relPath, relPath, relPath, relPath), err.Error())
+		})
+		t.Run("link refs", func(t *testing.T) {
+			_, err = mdformatter.IsFormatted(context.TODO(), logger, []string{testFile}, mdformatter.WithLinkTransformer(
+				MustNewValidator(logger, []byte("version: 1\n\nvalidators:\n  - regex: 'bwplotka\\/mdox'\n    type: 'githubLocal'\n"), anchorDir),
+			))
+			testutil.NotOk(t, err)
+			testutil.Equals(t, fmt.Sprintf("%v: 6 errors: "+
+				"%v:7: https://github.com/bwplotka/mdox/blob/v0.1.0/docs/doc2.md: docs/doc2.md not found in local repository at v0.1.0; "+
+				"%v:3: https://github.com/bwplotka/mdox/blob/main/pkg/b.go: pkg/b.go not found in local repository at main; "+
+				"%v:7: https://github.com/bwplotka/mdox/blob/main/pkg/%%zz.go: invalid URL escape \"%%zz\"; "+
+				"%v:5: https://github.com/bwplotka/mdox/blob/main/docs/doc2.md#yolo-2: docs/doc2.md not found in local repository at main; "+
+				"%v:5: https://github.com/bwplotka/mdox/blob/main/docs/doc2.md#user-content-yolo: docs/doc2.md not found in local repository at main; "+
+				"%v:5: https://github.com/bwplotka/mdox/blob/main/docs/doc2.md#nope: docs/doc2.md not found in local repository at main", testFile, relPath, relPath, relPath, relPath, relPath, relPath), err.Error())
+		})
+	})
 }
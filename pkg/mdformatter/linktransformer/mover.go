@@ -0,0 +1,418 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/gohugoio/hugo/parser/pageparser"
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+type mover struct {
+	anchorDir string
+	moves     map[string]string
+}
+
+// NewMover returns mdformatter.LinkTransformer that rewrites local links, so they are still valid after files are
+// moved. Moves map absolute paths of files and directories before move to paths after it, content of directories is
+// moved with them. Transformed files are expected to be in their locations before move. Links that do not need change
+// are left untouched.
+func NewMover(anchorDir string, moves map[string]string) mdformatter.LinkTransformer {
+	return &mover{anchorDir: anchorDir, moves: moves}
+}
+
+func (m *mover) TransformDestination(ctx mdformatter.SourceContext, destination []byte) ([]byte, error) {
+	dest := string(destination)
+	if schemeRe.MatchString(dest) || strings.HasPrefix(dest, "#") {
+		return destination, nil
+	}
+
+	split := splitLocalLink(absLocalLink(m.anchorDir, ctx.Filepath, dest))
+	target, query := splitQuery(split[0])
+	if target == ctx.Filepath {
+		// Link to itself stays the same, no matter where file is.
+		return destination, nil
+	}
+
+	newTarget, newFilepath := m.moved(target), m.moved(ctx.Filepath)
+	oldRel, err := filepath.Rel(filepath.Dir(ctx.Filepath), target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "link %v", dest)
+	}
+	newRel, err := filepath.Rel(filepath.Dir(newFilepath), newTarget)
+	if err != nil {
+		return nil, errors.Wrapf(err, "link %v", dest)
+	}
+	if filepath.IsAbs(dest) {
+		if newTarget == target {
+			return destination, nil
+		}
+		newRel, err = filepath.Rel(m.anchorDir, newTarget)
+		if err != nil {
+			return nil, errors.Wrapf(err, "link %v", dest)
+		}
+		newRel = "/" + newRel
+	} else if oldRel == newRel {
+		return destination, nil
+	}
+
+	newDest := filepath.ToSlash(newRel)
+	if p, _ := splitQuery(strings.Split(dest, "#")[0]); strings.HasSuffix(p, "/") {
+		newDest += "/"
+	}
+	newDest += query
+	if len(split) > 1 {
+		newDest += "#" + split[1]
+	}
+	return []byte(newDest), nil
+}
+
+// moved returns path after move of given file or directory, also if it's in moved directory.
+func (m *mover) moved(path string) string {
+	for p := path; ; p = filepath.Dir(p) {
+		if to, ok := m.moves[p]; ok {
+			return filepath.Join(to, strings.TrimPrefix(path, p))
+		}
+		if p == filepath.Dir(p) {
+			return path
+		}
+	}
+}
+
+func (m *mover) Close(mdformatter.SourceContext) error { return nil }
+
+// MoveLinks returns content of given markdown files with local links rewritten by NewMover for given moves. Only link
+// destinations are changed, the rest of files is kept as it is, so files are not formatted. Content is returned only
+// for files with changed links and is keyed by path of file after move.
+func MoveLinks(ctx context.Context, anchorDir string, moves map[string]string, files []string) (map[string][]byte, error) {
+	m := &mover{anchorDir: anchorDir, moves: moves}
+	contents := map[string][]byte{}
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		content, offset := source, 0
+		if fm, err := pageparser.ParseFrontMatterAndContent(bytes.NewReader(source)); err == nil && len(fm.FrontMatter) > 0 && bytes.HasSuffix(source, fm.Content) {
+			content, offset = fm.Content, len(source)-len(fm.Content)
+		}
+		dests, err := linkDestinations(content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%v", file)
+		}
+
+		b := bytes.Buffer{}
+		last, changed := 0, false
+		for _, d := range dests {
+			dest := d.unescaped(content)
+			newDest, err := m.TransformDestination(mdformatter.SourceContext{Context: ctx, Filepath: file}, dest)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%v", file)
+			}
+			if bytes.Equal(newDest, dest) {
+				continue
+			}
+			b.Write(source[last : offset+d.start])
+			b.Write(d.escape(newDest))
+			last, changed = offset+d.stop, true
+		}
+		if !changed {
+			continue
+		}
+		b.Write(source[last:])
+		contents[m.moved(file)] = b.Bytes()
+	}
+	return contents, nil
+}
+
+// linkDestination is a destination of link, image, link reference definition or HTML `src` and `href` attribute
+// written in markdown source.
+type linkDestination struct {
+	// start and stop are offsets of destination in source, without angle brackets and quotes.
+	start, stop int
+	angle, html bool
+}
+
+// unescaped returns destination with backslash escapes and entity references resolved.
+func (d linkDestination) unescaped(source []byte) []byte {
+	raw := source[d.start:d.stop]
+	if d.html {
+		return []byte(html.UnescapeString(string(raw)))
+	}
+	return util.ResolveEntityNames(util.ResolveNumericReferences(util.UnescapePunctuations(raw)))
+}
+
+// escape returns given destination escaped, so it can replace this one in source.
+func (d linkDestination) escape(dest []byte) []byte {
+	if d.html {
+		return []byte(html.EscapeString(string(dest)))
+	}
+	b := bytes.Buffer{}
+	angle := d.angle || bytes.ContainsAny(dest, " \t")
+	if angle && !d.angle {
+		b.WriteByte('<')
+	}
+	for _, c := range dest {
+		switch c {
+		case '\\', '<', '>':
+			b.WriteByte('\\')
+		case '(', ')':
+			if !angle {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteByte(c)
+	}
+	if angle && !d.angle {
+		b.WriteByte('>')
+	}
+	return b.Bytes()
+}
+
+var (
+	htmlDestinationRe         = regexp.MustCompile(`(?i)\s(?:src|href)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+))`)
+	linkReferenceDefinitionRe = regexp.MustCompile(`^ {0,3}\[((?:[^\]\\]|\\.)+)\]:[ \t]*`)
+)
+
+// linkDestinations returns destinations of all links in given markdown source, sorted by their position. Links are
+// found by parsing source the same way mdformatter does, so code blocks and code spans are skipped. Goldmark does not
+// record where link destinations are, so they are found in source after text of links, in link reference definitions
+// removed from paragraphs by parser and in segments of raw HTML.
+func linkDestinations(source []byte) ([]linkDestination, error) {
+	defs := &definitionLines{paragraphs: map[*ast.Paragraph][]text.Segment{}}
+	pc := parser.NewContext()
+	doc := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(
+			parser.WithAttribute(), parser.WithHeadingAttribute(),
+			// Run just before link reference definitions are removed from paragraphs.
+			parser.WithParagraphTransformers(util.Prioritized(defs, 99)),
+		),
+	).Parser().Parse(text.NewReader(source), parser.WithContext(pc))
+
+	var (
+		dests []linkDestination
+		found = map[int]struct{}{}
+		// stops are offsets in source after destinations of already visited links and images.
+		stops = map[ast.Node]int{}
+		// unresolved are destinations of links not found after their text, those are defined by link reference
+		// definitions.
+		unresolved = map[string]struct{}{}
+	)
+	if err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		var destination []byte
+		switch typedNode := n.(type) {
+		case *ast.Link:
+			destination = typedNode.Destination
+		case *ast.Image:
+			destination = typedNode.Destination
+		case *ast.RawHTML:
+			if entering {
+				for i := 0; i < typedNode.Segments.Len(); i++ {
+					dests = append(dests, htmlDestinations(source, typedNode.Segments.At(i))...)
+				}
+			}
+			return ast.WalkContinue, nil
+		case *ast.HTMLBlock:
+			if entering {
+				for i := 0; i < typedNode.Lines().Len(); i++ {
+					dests = append(dests, htmlDestinations(source, typedNode.Lines().At(i))...)
+				}
+				if typedNode.HasClosure() {
+					dests = append(dests, htmlDestinations(source, typedNode.ClosureLine)...)
+				}
+			}
+			return ast.WalkContinue, nil
+		default:
+			return ast.WalkContinue, nil
+		}
+		// Visit links when leaving them, so destinations of images in their text are already known.
+		if entering {
+			return ast.WalkContinue, nil
+		}
+
+		// Destination of inline link follows its text, e.g `[text](destination)`.
+		d, ok := linkDestination{}, false
+		if from := lastStop(n, stops); from >= 0 {
+			if i := bytes.IndexByte(source[from:], ']'); i >= 0 && from+i+1 < len(source) && source[from+i+1] == '(' {
+				d, ok = scanDestination(source, from+i+2)
+			}
+		} else if from = searchStart(n, stops); from >= 0 {
+			if i := bytes.Index(source[from:], []byte("](")); i >= 0 {
+				d, ok = scanDestination(source, from+i+2)
+			}
+		}
+		if _, dup := found[d.start]; !ok || dup || !bytes.Equal(source[d.start:d.stop], destination) {
+			unresolved[string(destination)] = struct{}{}
+			return ast.WalkContinue, nil
+		}
+		dests = append(dests, d)
+		found[d.start] = struct{}{}
+		stops[n] = d.stop
+		return ast.WalkContinue, nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for p, lines := range defs.paragraphs {
+		kept := map[int]struct{}{}
+		if p.Parent() != nil {
+			for i := 0; i < p.Lines().Len(); i++ {
+				kept[p.Lines().At(i).Start] = struct{}{}
+			}
+		}
+		for i, line := range lines {
+			if _, ok := kept[line.Start]; ok {
+				continue
+			}
+			m := linkReferenceDefinitionRe.FindSubmatchIndex(line.Value(source))
+			if m == nil {
+				continue
+			}
+			ref, ok := pc.Reference(util.ToLinkReference(line.Value(source)[m[2]:m[3]]))
+			if !ok {
+				continue
+			}
+			start := line.Start + m[1]
+			if len(bytes.TrimSpace(source[start:line.Stop])) == 0 && i+1 < len(lines) {
+				// Destination is on the next line.
+				for start = lines[i+1].Start; start < lines[i+1].Stop && util.IsSpace(source[start]); start++ {
+				}
+			}
+			d, ok := scanDestination(source, start)
+			if _, dup := found[d.start]; !ok || dup || !bytes.Equal(source[d.start:d.stop], ref.Destination()) {
+				continue
+			}
+			dests = append(dests, d)
+			found[d.start] = struct{}{}
+			delete(unresolved, string(ref.Destination()))
+		}
+	}
+	if len(unresolved) > 0 {
+		missing := make([]string, 0, len(unresolved))
+		for dest := range unresolved {
+			missing = append(missing, dest)
+		}
+		sort.Strings(missing)
+		return nil, errors.Errorf("links %v not found in source, can't rewrite them", strings.Join(missing, ", "))
+	}
+
+	sort.Slice(dests, func(i, j int) bool { return dests[i].start < dests[j].start })
+	return dests, nil
+}
+
+// scanDestination returns link destination starting at given offset of source, parsed the same way goldmark does.
+func scanDestination(source []byte, start int) (linkDestination, bool) {
+	end := len(source)
+	if i := bytes.IndexByte(source[start:], '\n'); i >= 0 {
+		end = start + i
+	}
+	if start < end && source[start] == '<' {
+		for i := start + 1; i < end; i++ {
+			if source[i] == '\\' && i < end-1 && util.IsPunct(source[i+1]) {
+				i++
+				continue
+			}
+			if source[i] == '>' {
+				return linkDestination{start: start + 1, stop: i, angle: true}, true
+			}
+		}
+		return linkDestination{}, false
+	}
+	opened := 0
+	i := start
+	for ; i < end; i++ {
+		c := source[i]
+		if c == '\\' && i < end-1 && util.IsPunct(source[i+1]) {
+			i++
+			continue
+		}
+		if c == '(' {
+			opened++
+		} else if c == ')' {
+			if opened--; opened < 0 {
+				break
+			}
+		} else if util.IsSpace(c) {
+			break
+		}
+	}
+	return linkDestination{start: start, stop: i}, true
+}
+
+// lastStop returns offset in source after the last text or visited link destination in given inline node, -1 if it
+// has none.
+func lastStop(n ast.Node, stops map[ast.Node]int) int {
+	if stop, ok := stops[n]; ok {
+		return stop
+	}
+	switch typedNode := n.(type) {
+	case *ast.Text:
+		return typedNode.Segment.Stop
+	case *ast.RawHTML:
+		if typedNode.Segments.Len() > 0 {
+			return typedNode.Segments.At(typedNode.Segments.Len() - 1).Stop
+		}
+	}
+	for c := n.LastChild(); c != nil; c = c.PreviousSibling() {
+		if stop := lastStop(c, stops); stop >= 0 {
+			return stop
+		}
+	}
+	return -1
+}
+
+// searchStart returns offset in source before given inline node, after preceding text or link destinations.
+func searchStart(n ast.Node, stops map[ast.Node]int) int {
+	for p := n.PreviousSibling(); p != nil; p = p.PreviousSibling() {
+		if stop := lastStop(p, stops); stop >= 0 {
+			return stop
+		}
+	}
+	parent := n.Parent()
+	if parent == nil {
+		return -1
+	}
+	if parent.Type() == ast.TypeBlock && parent.Lines().Len() > 0 {
+		return parent.Lines().At(0).Start
+	}
+	return searchStart(parent, stops)
+}
+
+// htmlDestinations returns destinations of `src` and `href` attributes in given segment of raw HTML.
+func htmlDestinations(source []byte, segment text.Segment) []linkDestination {
+	var dests []linkDestination
+	for _, m := range htmlDestinationRe.FindAllSubmatchIndex(segment.Value(source), -1) {
+		for g := 2; g < len(m); g += 2 {
+			if m[g] >= 0 {
+				dests = append(dests, linkDestination{start: segment.Start + m[g], stop: segment.Start + m[g+1], html: true})
+			}
+		}
+	}
+	return dests
+}
+
+// definitionLines records lines of paragraphs before link reference definitions are removed from them.
+type definitionLines struct {
+	paragraphs map[*ast.Paragraph][]text.Segment
+}
+
+func (d *definitionLines) Transform(node *ast.Paragraph, _ text.Reader, _ parser.Context) {
+	d.paragraphs[node] = append([]text.Segment{}, node.Lines().Sliced(0, node.Lines().Len())...)
+}
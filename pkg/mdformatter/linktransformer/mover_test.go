@@ -0,0 +1,100 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/testutil"
+)
+
+func TestMover_TransformDestination(t *testing.T) {
+	anchorDir := filepath.Join("/", "repo")
+	m := NewMover(anchorDir, map[string]string{
+		filepath.Join(anchorDir, "docs", "old.md"): filepath.Join(anchorDir, "docs", "guides", "new.md"),
+		filepath.Join(anchorDir, "docs", "img"):    filepath.Join(anchorDir, "docs", "guides", "img"),
+	})
+
+	for _, tcase := range []struct {
+		file     string
+		dest     string
+		expected string
+	}{
+		// Links to moved files.
+		{file: "README.md", dest: "docs/old.md", expected: "docs/guides/new.md"},
+		{file: "README.md", dest: "./docs/old.md#some-header", expected: "docs/guides/new.md#some-header"},
+		{file: "README.md", dest: "/docs/old.md", expected: "/docs/guides/new.md"},
+		{file: "README.md", dest: "docs/img/", expected: "docs/guides/img/"},
+		{file: "docs/other.md", dest: "img/a.png", expected: "guides/img/a.png"},
+		{file: "docs/other.md", dest: "img/a.png?raw=true", expected: "guides/img/a.png?raw=true"},
+		// Links in moved file.
+		{file: "docs/old.md", dest: "other.md", expected: "../other.md"},
+		{file: "docs/old.md", dest: "img/a.png", expected: "img/a.png"},
+		{file: "docs/old.md", dest: "/README.md", expected: "/README.md"},
+		{file: "docs/old.md", dest: "#some-header", expected: "#some-header"},
+		{file: "docs/old.md", dest: "old.md#some-header", expected: "old.md#some-header"},
+		// Not affected links.
+		{file: "README.md", dest: "docs/other.md", expected: "docs/other.md"},
+		{file: "README.md", dest: "https://example.com/docs/old.md", expected: "https://example.com/docs/old.md"},
+	} {
+		t.Run(tcase.file+" "+tcase.dest, func(t *testing.T) {
+			res, err := m.TransformDestination(mdformatter.SourceContext{Filepath: filepath.Join(anchorDir, tcase.file)}, []byte(tcase.dest))
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, string(res))
+		})
+	}
+}
+
+func TestMoveLinks(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-move-links")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, os.MkdirAll(filepath.Join(tmpDir, "docs"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "README.md"), []byte(`# Not   formatted
+
+* [Old](docs/old.md) and [old again](docs/old.md "Title"), [header](./docs/old.md#header) [other](docs/other.md).
+* [Reference][ref] <img src="docs/old.md">
+* [Escaped](docs/old\_link.md) [angle](<docs/old.md>) ![](docs/old.md) [![](docs/old.md)](docs/old.md)
+* Code span `+"`[Old](docs/old.md)`"+`.
+
+`+"```"+`
+[Old](docs/old.md)
+`+"```"+`
+
+<a href='docs/old.md'>HTML block</a>
+
+[ref]: docs/old.md
+`), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "docs", "old.md"), []byte("---\ntitle: \"[Readme](../README.md)\"\n---\n\n[Readme](../README.md)   [self](#header)\n"), os.ModePerm))
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "docs", "other.md"), []byte("[Readme](../README.md)\n"), os.ModePerm))
+
+	contents, err := MoveLinks(context.Background(), tmpDir, map[string]string{
+		filepath.Join(tmpDir, "docs", "old.md"):      filepath.Join(tmpDir, "docs", "guides", "new.md"),
+		filepath.Join(tmpDir, "docs", "old_link.md"): filepath.Join(tmpDir, "docs", "guides", "new_link.md"),
+	}, []string{filepath.Join(tmpDir, "README.md"), filepath.Join(tmpDir, "docs", "old.md"), filepath.Join(tmpDir, "docs", "other.md")})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(contents))
+	testutil.Equals(t, `# Not   formatted
+
+* [Old](docs/guides/new.md) and [old again](docs/guides/new.md "Title"), [header](docs/guides/new.md#header) [other](docs/other.md).
+* [Reference][ref] <img src="docs/guides/new.md">
+* [Escaped](docs/guides/new_link.md) [angle](<docs/guides/new.md>) ![](docs/guides/new.md) [![](docs/guides/new.md)](docs/guides/new.md)
+* Code span `+"`[Old](docs/old.md)`"+`.
+
+`+"```"+`
+[Old](docs/old.md)
+`+"```"+`
+
+<a href='docs/guides/new.md'>HTML block</a>
+
+[ref]: docs/guides/new.md
+`, string(contents[filepath.Join(tmpDir, "README.md")]))
+	testutil.Equals(t, "---\ntitle: \"[Readme](../README.md)\"\n---\n\n[Readme](../../README.md)   [self](#header)\n", string(contents[filepath.Join(tmpDir, "docs", "guides", "new.md")]))
+}
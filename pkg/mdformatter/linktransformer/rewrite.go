@@ -0,0 +1,121 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gobwas/glob"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type RewriteConfig struct {
+	Version int `yaml:"version"`
+
+	// Rules are applied to every link destination in order, each to the result of the previous one.
+	Rules []RewriteRule `yaml:"rules"`
+}
+
+type RewriteRule struct {
+	_regex *regexp.Regexp
+	_glob  glob.Glob
+
+	// Regex is matched against link destination, e.g `^https://github.com/bwplotka/mdox/blob/master/`.
+	Regex string `yaml:"regex"`
+	// Replacement replaces all matches of regex. It can reference capture groups as `$1` or `${name}`,
+	// e.g `https://github.com/bwplotka/mdox/blob/main/`.
+	Replacement string `yaml:"replacement"`
+	// FileGlob optionally limits rule to markdown files matching glob (https://github.com/gobwas/glob), matched
+	// against slash separated path of file relative to anchor dir, e.g `docs/**`.
+	FileGlob string `yaml:"fileGlob"`
+}
+
+// ParseRewriteConfig parses and validates YAML rewrite configuration.
+func ParseRewriteConfig(c []byte) (RewriteConfig, error) {
+	cfg := RewriteConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(c))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return RewriteConfig{}, errors.Wrap(err, "parsing rewrite config")
+	}
+	if err := compileRewriteRules(cfg.Rules); err != nil {
+		return RewriteConfig{}, err
+	}
+	return cfg, nil
+}
+
+func compileRewriteRules(rules []RewriteRule) (err error) {
+	if len(rules) == 0 {
+		return errors.New("no rewrite rule provided")
+	}
+	for i := range rules {
+		if rules[i].Regex == "" {
+			return errors.Errorf("rule %v: regex field is required", i)
+		}
+		rules[i]._regex, err = regexp.Compile(rules[i].Regex)
+		if err != nil {
+			return errors.Wrapf(err, "rule %v: compiling regex %v", i, rules[i].Regex)
+		}
+		if rules[i].FileGlob != "" {
+			rules[i]._glob, err = glob.Compile(rules[i].FileGlob, '/')
+			if err != nil {
+				return errors.Wrapf(err, "rule %v: compiling glob %v", i, rules[i].FileGlob)
+			}
+		}
+	}
+	return nil
+}
+
+type rewriter struct {
+	logger    log.Logger
+	anchorDir string
+	rules     []RewriteRule
+}
+
+// NewRewriter returns mdformatter.LinkTransformer that rewrites link destinations using regex rules from
+// given YAML configuration with spec defined in RewriteConfig.
+func NewRewriter(logger log.Logger, anchorDir string, config []byte) (mdformatter.LinkTransformer, error) {
+	cfg, err := ParseRewriteConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &rewriter{logger: logger, anchorDir: anchorDir, rules: cfg.Rules}, nil
+}
+
+// NewRewriterForRules is like NewRewriter, but takes already decoded rules, e.g embedded in other configuration.
+func NewRewriterForRules(logger log.Logger, anchorDir string, rules []RewriteRule) (mdformatter.LinkTransformer, error) {
+	rules = append([]RewriteRule(nil), rules...)
+	if err := compileRewriteRules(rules); err != nil {
+		return nil, err
+	}
+	return &rewriter{logger: logger, anchorDir: anchorDir, rules: rules}, nil
+}
+
+func (r *rewriter) TransformDestination(ctx mdformatter.SourceContext, destination []byte) ([]byte, error) {
+	relPath, err := filepath.Rel(r.anchorDir, ctx.Filepath)
+	if err != nil {
+		return nil, errors.Wrap(err, "rewrite: rel filepath to anchor dir")
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	newDest := destination
+	for _, rule := range r.rules {
+		if rule._glob != nil && !rule._glob.Match(relPath) {
+			continue
+		}
+		newDest = rule._regex.ReplaceAll(newDest, []byte(rule.Replacement))
+	}
+	if !bytes.Equal(newDest, destination) {
+		level.Debug(r.logger).Log("msg", "rewrote link", "file", ctx.Filepath, "from", string(destination), "to", string(newDest))
+	}
+	return newDest, nil
+}
+
+func (r *rewriter) Close(mdformatter.SourceContext) error { return nil }
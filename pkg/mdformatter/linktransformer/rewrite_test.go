@@ -0,0 +1,67 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package linktransformer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/efficientgo/tools/core/pkg/testutil"
+	"github.com/go-kit/kit/log"
+)
+
+func TestRewriter_TransformDestination(t *testing.T) {
+	anchorDir := filepath.Join("/", "repo")
+	r, err := NewRewriter(log.NewNopLogger(), anchorDir, []byte(`version: 1
+
+rules:
+  - regex: '^https://github.com/bwplotka/mdox/(blob|tree)/master/'
+    replacement: "https://github.com/bwplotka/mdox/$1/main/"
+  - regex: '^https://old.example.com/'
+    replacement: "https://new.example.com/"
+  - regex: '^https://new.example.com/docs/latest/'
+    replacement: "https://new.example.com/docs/v1/"
+    fileGlob: "docs/v1/**"
+`))
+	testutil.Ok(t, err)
+
+	for _, tcase := range []struct {
+		file     string
+		dest     string
+		expected string
+	}{
+		{file: "README.md", dest: "https://github.com/bwplotka/mdox/blob/master/README.md#usage", expected: "https://github.com/bwplotka/mdox/blob/main/README.md#usage"},
+		{file: "README.md", dest: "https://github.com/bwplotka/mdox/tree/master/pkg", expected: "https://github.com/bwplotka/mdox/tree/main/pkg"},
+		{file: "README.md", dest: "https://github.com/bwplotka/mdox/blob/v0.9.0/README.md", expected: "https://github.com/bwplotka/mdox/blob/v0.9.0/README.md"},
+		{file: "README.md", dest: "docs/old.md", expected: "docs/old.md"},
+		// Rules are applied one after another and only to files matching glob.
+		{file: "README.md", dest: "https://old.example.com/docs/latest/a.md", expected: "https://new.example.com/docs/latest/a.md"},
+		{file: "docs/v1/guides/a.md", dest: "https://old.example.com/docs/latest/a.md", expected: "https://new.example.com/docs/v1/a.md"},
+	} {
+		t.Run(tcase.file+" "+tcase.dest, func(t *testing.T) {
+			res, err := r.TransformDestination(mdformatter.SourceContext{Filepath: filepath.Join(anchorDir, tcase.file)}, []byte(tcase.dest))
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, string(res))
+		})
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewRewriter(log.NewNopLogger(), anchorDir, []byte("version: 1\n"))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "no rewrite rule provided", err.Error())
+
+		_, err = NewRewriter(log.NewNopLogger(), anchorDir, []byte("rules:\n  - regex: '('\n"))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "rule 0: compiling regex (: error parsing regexp: missing closing ): `(`", err.Error())
+
+		_, err = NewRewriter(log.NewNopLogger(), anchorDir, []byte("rules:\n  - replacement: 'a'\n"))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "rule 0: regex field is required", err.Error())
+
+		_, err = NewRewriter(log.NewNopLogger(), anchorDir, []byte("version: 1\ntoken: 'secret'\n"))
+		testutil.NotOk(t, err)
+		testutil.Equals(t, "parsing rewrite config: yaml: unmarshal errors:\n  line 2: field token not found in type linktransformer.RewriteConfig", err.Error())
+	})
+}
@@ -4,39 +4,177 @@
 package linktransformer
 
 import (
+	"bytes"
+	"net/url"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/bwplotka/mdox/pkg/mdformatter/slug"
 	"github.com/pkg/errors"
 )
 
+const gitHubUserContentPrefix = "user-content-"
+
+var (
+	gitHubURLRe          = regexp.MustCompile(`^http[s]?://(www\.)?github\.com/`)
+	gitHubLineFragmentRe = regexp.MustCompile(`^L(\d+)(?:C\d+)?(?:-L(\d+)(?:C\d+)?)?$`)
+	gitHubNumberRe       = regexp.MustCompile(`^\d+`)
+	gitHubCommitRe       = regexp.MustCompile(`^[0-9a-fA-F]{7,40}\b`)
+	gitHubRepoRe         = regexp.MustCompile(`^http[s]?://(?:www\.)?github\.com/([^/]+/[^/]+)/`)
+)
+
 type Validator interface {
 	IsValid(k futureKey, r *validator) (bool, error)
 }
 
-// GitHubValidator.IsValid skips visiting all github issue/PR links.
+// GitHubValidator.IsValid skips visiting all github issue/PR links and, if enabled, commit links. Issue and PR links
+// are checked against the latest number, commit links of local repository against local git repository.
 func (v GitHubValidator) IsValid(k futureKey, r *validator) (bool, error) {
 	// Find rightmost index of match i.e, where regex match ends.
-	// This will be where issue/PR number starts. Split incase of section link and convert to int.
-	rightmostIndex := v._regex.FindStringIndex(k.dest)
-	stringNum := strings.Split(k.dest[rightmostIndex[1]:], "#")
-	num, err := strconv.Atoi(stringNum[0])
+	// This will be where issue/PR number or commit SHA starts.
+	match := v._regex.FindStringSubmatchIndex(k.dest)
+	rest := k.dest[match[1]:]
+	if kind := 2 * v._regex.SubexpIndex("kind"); k.dest[match[kind]:match[kind+1]] == "/commit/" {
+		return v.isValidCommit(k, r, rest)
+	}
+
+	num, err := strconv.Atoi(gitHubNumberRe.FindString(rest))
 	if err != nil {
-		return false, err
+		r.destFutures[k].resultFn = func() error { return errors.Errorf("%v: issue or pull request number not found", k.dest) }
+		return true, nil
+	}
+	max, err := v._maxNum.get(num)
+	if err != nil {
+		r.destFutures[k].resultFn = func() error { return errors.Wrapf(err, "%v", k.dest) }
+		return true, nil
 	}
 	// If number in link does not exceed then link is valid.
-	if v._maxNum >= num {
+	if max < num {
+		r.destFutures[k].resultFn = func() error {
+			return errors.Errorf("%v: issue or pull request #%v does not exist, the latest one is #%v", k.dest, num, max)
+		}
+	}
+	return true, nil
+}
+
+// isValidCommit checks commit link in local git repository, if it's link to local repository. Other commit links
+// are visited.
+func (v GitHubValidator) isValidCommit(k futureKey, r *validator, rest string) (bool, error) {
+	local, err := v._commits.isLocal(r.anchorDir, gitHubRepoRe.FindStringSubmatch(k.dest)[1])
+	if err != nil {
+		r.destFutures[k].resultFn = func() error { return errors.Wrapf(err, "%v", k.dest) }
+		return true, nil
+	}
+	if !local {
+		return RoundTripValidator{}.IsValid(k, r)
+	}
+
+	sha := gitHubCommitRe.FindString(rest)
+	if sha == "" {
+		r.destFutures[k].resultFn = func() error { return errors.Errorf("%v: commit SHA not found", k.dest) }
+		return true, nil
+	}
+	if err := v._commits.check(r.anchorDir, sha); err != nil {
+		r.destFutures[k].resultFn = func() error { return errors.Wrapf(err, "%v", k.dest) }
+	}
+	return true, nil
+}
+
+// GitHubLocalValidator.IsValid skips visiting github blob/tree links to files of the repo, as they are checked in
+// local repository instead, at the ref from the link. Linked file or directory has to exist, line fragments
+// (e.g `#L10-L20`) have to be within the file and other fragments of markdown files have to match their anchors.
+// Links at refs which do not exist in local repository are visited.
+func (v GitHubLocalValidator) IsValid(k futureKey, r *validator) (bool, error) {
+	match := v._regex.FindStringSubmatchIndex(k.dest)
+	rest, fragment := splitFragment(k.dest[match[1]:])
+	if i := strings.Index(rest, "?"); i >= 0 {
+		rest = rest[:i]
+	}
+	rest, err := url.PathUnescape(rest)
+	if err != nil {
+		r.destFutures[k].resultFn = func() error { return errors.Wrapf(err, "%v", k.dest) }
 		return true, nil
 	}
-	return false, nil
+	ref, p, ok, err := v._repo.splitRef(r.anchorDir, rest)
+	if err != nil {
+		r.destFutures[k].resultFn = func() error { return errors.Wrapf(err, "%v", k.dest) }
+		return true, nil
+	}
+	if !ok {
+		return RoundTripValidator{}.IsValid(k, r)
+	}
+	p = path.Clean("/" + p)[1:]
+
+	if err := v.checkPath(r.anchorDir, ref, p, fragment); err != nil {
+		r.destFutures[k].resultFn = func() error { return errors.Wrapf(err, "%v", k.dest) }
+	}
+	return true, nil
+}
+
+func (v GitHubLocalValidator) checkPath(dir string, ref string, p string, fragment string) error {
+	where := "local repository"
+	if ref != "" {
+		where += " at " + ref
+	}
+	content, isDir, err := v._repo.read(dir, ref, p)
+	if err != nil {
+		if err == errNotFound {
+			return errors.Errorf("%v not found in %v", p, where)
+		}
+		return err
+	}
+	if fragment == "" || isDir {
+		return nil
+	}
+
+	if m := gitHubLineFragmentRe.FindStringSubmatch(fragment); m != nil {
+		lines := bytes.Count(content, []byte("\n"))
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			lines++
+		}
+		for _, l := range m[1:] {
+			if l == "" {
+				continue
+			}
+			if n, _ := strconv.Atoi(l); n < 1 || n > lines {
+				return errors.Errorf("line %v out of range, %v has %v lines in %v", n, p, lines, where)
+			}
+		}
+		return nil
+	}
+	if !IsMarkdownFile(p) {
+		return nil
+	}
+	// GitHub renders markdown files, so anchors are generated the GitHub way.
+	ids, err := markdownAnchors(content, slug.GitHub)
+	if err != nil {
+		return errors.Wrapf(err, "parse %v", p)
+	}
+	fragment = strings.TrimPrefix(fragment, gitHubUserContentPrefix)
+	for _, id := range ids {
+		if id == fragment {
+			return nil
+		}
+	}
+	return errors.Errorf("%v exists, but does not have element with %q id in %v", p, fragment, where)
 }
 
 // RoundTripValidator.IsValid returns true if url is checked by colly.
 func (v RoundTripValidator) IsValid(k futureKey, r *validator) (bool, error) {
+	// Pages are visited once, no matter the fragment.
+	url, fragment := splitFragment(k.dest)
+
 	// Result will be in future.
-	r.destFutures[k].resultFn = func() error { return r.remoteLinks[k.dest] }
+	r.destFutures[k].resultFn = func() error {
+		if err := r.remoteLinks[url]; err != nil || !v._checkFragments || fragment == "" {
+			return err
+		}
+		return checkRemoteFragment(url, fragment, r.remoteAnchors[url])
+	}
 	r.rMu.RLock()
-	if _, ok := r.remoteLinks[k.dest]; ok {
+	if _, ok := r.remoteLinks[url]; ok {
 		r.rMu.RUnlock()
 		return true, nil
 	}
@@ -45,17 +183,60 @@ func (v RoundTripValidator) IsValid(k futureKey, r *validator) (bool, error) {
 	r.rMu.Lock()
 	defer r.rMu.Unlock()
 	// We need to check again here to avoid race.
-	if _, ok := r.remoteLinks[k.dest]; ok {
+	if _, ok := r.remoteLinks[url]; ok {
 		return true, nil
 	}
 
-	if err := r.c.Visit(k.dest); err != nil {
-		r.remoteLinks[k.dest] = errors.Wrapf(err, "remote link %v", k.dest)
+	c := r.c
+	if v._c != nil {
+		c = v._c
+	}
+	visit := c.Visit
+	if v._headFirst {
+		visit = c.Head
+	}
+	if err := visit(url); err != nil {
+		r.remoteLinks[url] = errors.Wrapf(err, "remote link %v", url)
 		return false, nil
 	}
 	return true, nil
 }
 
+// splitFragment splits URL into URL without fragment and fragment.
+func splitFragment(url string) (string, string) {
+	if i := strings.Index(url, "#"); i >= 0 {
+		return url[:i], url[i+1:]
+	}
+	return url, ""
+}
+
+// checkRemoteFragment returns error if fragment is not one of given anchors from the page under url.
+func checkRemoteFragment(pageURL string, fragment string, anchors *[]string) error {
+	if anchors == nil {
+		// Not HTML page, nothing to check.
+		return nil
+	}
+	// Fragment might be percent-encoded, e.g `#caf%C3%A9`, while IDs in HTML are not.
+	if f, err := url.PathUnescape(fragment); err == nil {
+		fragment = f
+	}
+	prefix := ""
+	if gitHubURLRe.MatchString(pageURL) {
+		// GitHub prefixes IDs of rendered markdown elements and creates line anchors dynamically.
+		if gitHubLineFragmentRe.MatchString(fragment) {
+			return nil
+		}
+		fragment = strings.TrimPrefix(fragment, gitHubUserContentPrefix)
+		prefix = gitHubUserContentPrefix
+	}
+	for _, a := range *anchors {
+		if a == fragment || a == prefix+fragment {
+			return nil
+		}
+	}
+	return errors.Errorf("%q exists, but does not have element with %q id", pageURL, fragment)
+}
+
 // IgnoreValidator.IsValid returns true if matched so that link in not checked.
 func (v IgnoreValidator) IsValid(k futureKey, r *validator) (bool, error) {
 	return true, nil
@@ -63,27 +244,53 @@ func (v IgnoreValidator) IsValid(k futureKey, r *validator) (bool, error) {
 
 // GetValidatorForURL returns correct Validator by matching URL.
 func (v Config) GetValidatorForURL(URL string) Validator {
+	val, ok := v.validatorConfigForURL(URL)
+	if !ok {
+		// No config file passed, so all links must be checked.
+		return RoundTripValidator{}
+	}
+	switch val.Type {
+	case roundtripValidator:
+		return val.rtValidator
+	case githubValidator:
+		return val.ghValidator
+	case githubLocalValidator:
+		return val.glValidator
+	case ignoreValidator:
+		return val.igValidator
+	default:
+		panic("unexpected validator type")
+	}
+}
+
+// GetSeverityForURL returns severity of invalid URL, configured in validator matching it.
+func (v Config) GetSeverityForURL(URL string) Severity {
+	val, ok := v.validatorConfigForURL(URL)
+	if !ok {
+		return SeverityError
+	}
+	return val.Severity
+}
+
+// validatorConfigForURL returns the first validator config matching URL.
+func (v Config) validatorConfigForURL(URL string) (ValidatorConfig, bool) {
 	for _, val := range v.Validators {
+		var re *regexp.Regexp
 		switch val.Type {
 		case roundtripValidator:
-			if !val.rtValidator._regex.MatchString(URL) {
-				continue
-			}
-			return val.rtValidator
+			re = val.rtValidator._regex
 		case githubValidator:
-			if !val.ghValidator._regex.MatchString(URL) {
-				continue
-			}
-			return val.ghValidator
+			re = val.ghValidator._regex
+		case githubLocalValidator:
+			re = val.glValidator._regex
 		case ignoreValidator:
-			if !val.igValidator._regex.MatchString(URL) {
-				continue
-			}
-			return val.igValidator
+			re = val.igValidator._regex
 		default:
 			panic("unexpected validator type")
 		}
+		if re.MatchString(URL) {
+			return val, true
+		}
 	}
-	// No config file passed, so all links must be checked.
-	return RoundTripValidator{}
+	return ValidatorConfig{}, false
 }
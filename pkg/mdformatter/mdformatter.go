@@ -22,8 +22,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/theckman/yacspin"
 	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/util"
 	"gopkg.in/yaml.v3"
 )
 
@@ -49,18 +50,97 @@ type LinkTransformer interface {
 	Close(ctx SourceContext) error
 }
 
+// LinkKind is a kind of node link was found in.
+type LinkKind string
+
+const (
+	// LinkKindLink is a markdown link, e.g `[text](destination "title")`.
+	LinkKindLink LinkKind = "link"
+	// LinkKindImage is a markdown image, e.g `![alt](destination "title")`.
+	LinkKindImage LinkKind = "image"
+	// LinkKindAutoLink is an URL autolink, e.g `<https://example.com>` or just `https://example.com`.
+	LinkKindAutoLink LinkKind = "autolink"
+	// LinkKindHTMLAnchor is a `href` attribute of HTML `<a>` tag.
+	LinkKindHTMLAnchor LinkKind = "htmlAnchor"
+	// LinkKindHTMLImage is a `src` attribute of HTML `<img>` tag.
+	LinkKindHTMLImage LinkKind = "htmlImage"
+)
+
+// Link is a link found in markdown file, which StructuredLinkTransformer can modify.
+type Link struct {
+	Kind        LinkKind
+	Destination []byte
+	// Text is a plain text of link (without markdown formatting) or alt text of image. It's empty for HTML `<a>` tags,
+	// which text can't be changed. If changed, content of link is replaced with given text, rendered as it is.
+	Text []byte
+	// Title is an optional title of link or image, e.g `[text](destination "title")` or `title` attribute of HTML tag.
+	Title []byte
+	// Remove set to true removes the link, but keeps its text.
+	Remove bool
+}
+
+// StructuredLinkTransformer is a LinkTransformer that can read and modify whole links, not only their destinations.
+// If LinkTransformer implements it, TransformLink is used instead of TransformDestination.
+type StructuredLinkTransformer interface {
+	LinkTransformer
+	TransformLink(ctx SourceContext, link *Link) error
+}
+
+// TransformLink transforms given link using TransformLink if given transformer is StructuredLinkTransformer or
+// TransformDestination otherwise.
+func TransformLink(ctx SourceContext, t LinkTransformer, link *Link) (err error) {
+	if st, ok := t.(StructuredLinkTransformer); ok {
+		return st.TransformLink(ctx, link)
+	}
+	link.Destination, err = t.TransformDestination(ctx, link.Destination)
+	return err
+}
+
 type CodeBlockTransformer interface {
 	TransformCodeBlock(ctx SourceContext, infoString []byte, code []byte) ([]byte, error)
 	Close(ctx SourceContext) error
 }
 
+// Heading is a heading found in markdown file, which HeadingTransformer can modify.
+type Heading struct {
+	// Level is a level of heading, from 1 to 6.
+	Level int
+	// Text is a plain text of heading (without markdown formatting). If changed, content of heading is replaced with
+	// given text, rendered as it is.
+	Text []byte
+	// ID is a custom heading ID, e.g `custom-id` for `# Heading {#custom-id}`. Empty if heading does not have one.
+	ID []byte
+}
+
+// HeadingTransformer transforms headings. SourceContext.LineNumbers is empty for headings without text (e.g `#`), as
+// their position is unknown.
+type HeadingTransformer interface {
+	TransformHeading(ctx SourceContext, heading *Heading) error
+	Close(ctx SourceContext) error
+}
+
+// TextTransformer transforms prose text e.g of paragraphs, headings, lists, tables and links text. Text of code
+// blocks, code spans and HTML is not passed. Returned text is rendered as it is.
+type TextTransformer interface {
+	TransformText(ctx SourceContext, text []byte) ([]byte, error)
+	Close(ctx SourceContext) error
+}
+
 type Formatter struct {
 	ctx context.Context
 
-	fm   FrontMatterTransformer
-	bm   BackMatterTransformer
-	link LinkTransformer
-	cb   CodeBlockTransformer
+	fm      FrontMatterTransformer
+	bm      BackMatterTransformer
+	link    LinkTransformer
+	cb      CodeBlockTransformer
+	heading HeadingTransformer
+	text    TextTransformer
+	// formatOffLinks makes link transformer see links in FormatOffRegion nodes.
+	formatOffLinks bool
+
+	extensions      []goldmark.Extender
+	astTransformers []util.PrioritizedValue
+	nodeRenderers   map[ast.NodeKind]NodeRenderer
 }
 
 // Option is a functional option type for Formatter objects.
@@ -94,10 +174,38 @@ func WithCodeBlockTransformer(cb CodeBlockTransformer) Option {
 	}
 }
 
+// WithHeadingTransformer allows you to override the default HeadingTransformer.
+func WithHeadingTransformer(h HeadingTransformer) Option {
+	return func(m *Formatter) {
+		m.heading = h
+	}
+}
+
+// WithTextTransformer allows you to override the default TextTransformer.
+func WithTextTransformer(t TextTransformer) Option {
+	return func(m *Formatter) {
+		m.text = t
+	}
+}
+
+// WithFormatOffRegionLinks makes LinkTransformer see links in regions between `<!-- mdox-fmt off -->` and
+// `<!-- mdox-fmt on -->` markers too, e.g to validate them. Regions are kept as they are, so changes of links in them
+// are not applied.
+func WithFormatOffRegionLinks() Option {
+	return func(m *Formatter) {
+		m.formatOffLinks = true
+	}
+}
+
 func New(ctx context.Context, opts ...Option) *Formatter {
 	f := &Formatter{
-		ctx: ctx,
-		fm:  FormatFrontMatterTransformer{},
+		ctx:           ctx,
+		fm:            FormatFrontMatterTransformer{},
+		extensions:    append([]goldmark.Extender{}, defaultExtensions...),
+		nodeRenderers: map[ast.NodeKind]NodeRenderer{},
+	}
+	for k, r := range defaultNodeRenderers {
+		f.nodeRenderers[k] = r
 	}
 	for _, opt := range opts {
 		opt(f)
@@ -310,10 +418,15 @@ func (f *Formatter) Format(file *os.File, out io.Writer) error {
 	}
 	content := b
 	frontMatter := map[string]interface{}{}
+	frontMatterLines := 0
 	fm, err := pageparser.ParseFrontMatterAndContent(bytes.NewReader(b))
 	if err == nil && len(fm.FrontMatter) > 0 {
 		content = fm.Content
 		frontMatter = fm.FrontMatter
+		if bytes.HasSuffix(b, content) {
+			// All lines before content belong to front matter.
+			frontMatterLines = bytes.Count(b[:len(b)-len(content)], []byte("\n"))
+		}
 	}
 
 	if f.fm != nil {
@@ -346,14 +459,19 @@ func (f *Formatter) Format(file *os.File, out io.Writer) error {
 	// This also immediately show transformers which are not working well together etc.
 	tmp := bytes.Buffer{}
 	tr := &transformer{
-		wrapped:   markdown.NewRenderer(),
+		wrapped:   extendedRenderer{wrapped: markdown.NewRenderer(), nodes: f.nodeRenderers},
 		sourceCtx: sourceCtx,
 		link:      f.link, cb: f.cb,
-		frontMatterLen: len(frontMatter),
+		heading: f.heading, text: f.text,
+		formatOffLinks:   f.formatOffLinks,
+		frontMatterLines: frontMatterLines,
 	}
 	if err := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
-		goldmark.WithParserOptions(parser.WithAttribute() /* Enable # headers {#custom-ids} */, parser.WithHeadingAttribute()),
+		goldmark.WithExtensions(f.extensions...),
+		goldmark.WithParserOptions(
+			parser.WithAttribute() /* Enable # headers {#custom-ids} */, parser.WithHeadingAttribute(),
+			parser.WithASTTransformers(f.astTransformers...),
+		),
 		goldmark.WithRenderer(nopOpsRenderer{Renderer: tr}),
 	).Convert(content, &tmp); err != nil {
 		return errors.Wrapf(err, "first formatting phase for %v", file.Name())
@@ -362,9 +480,9 @@ func (f *Formatter) Format(file *os.File, out io.Writer) error {
 		return errors.Wrapf(err, "%v", file.Name())
 	}
 	if err := goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithExtensions(f.extensions...),
 		goldmark.WithParserOptions(parser.WithAttribute() /* Enable # headers {#custom-ids} */, parser.WithHeadingAttribute()),
-		goldmark.WithRenderer(markdown.NewRenderer()), // No transforming for second phase.
+		goldmark.WithRenderer(extendedRenderer{wrapped: markdown.NewRenderer(), nodes: f.nodeRenderers}), // No transforming for second phase.
 	).Convert(tmp.Bytes(), out); err != nil {
 		return errors.Wrapf(err, "second formatting phase for %v", file.Name())
 	}
@@ -8,6 +8,9 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/efficientgo/tools/core/pkg/testutil"
@@ -107,3 +110,219 @@ func TestFormat_FormatSingle_Transformers(t *testing.T) {
 
 	testutil.Equals(t, true, m.closed)
 }
+
+type mockStructuredLinkTransformer struct {
+	mockLinkTransformer
+
+	kinds []LinkKind
+}
+
+func (m *mockStructuredLinkTransformer) TransformLink(_ SourceContext, link *Link) error {
+	m.kinds = append(m.kinds, link.Kind)
+	if bytes.Contains(link.Destination, []byte("remove")) {
+		link.Remove = true
+		return nil
+	}
+	switch link.Kind {
+	case LinkKindLink:
+		link.Text = bytes.ToUpper(link.Text)
+	case LinkKindImage, LinkKindHTMLImage:
+		link.Title = append([]byte("Image: "), link.Text...)
+	case LinkKindAutoLink:
+		link.Text = []byte("Example")
+	case LinkKindHTMLAnchor:
+		link.Destination = append(link.Destination, []byte("#anchor")...)
+	}
+	return nil
+}
+
+func TestFormat_FormatSingle_StructuredLinkTransformer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-structured-link")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "doc.md"), []byte(`# Doc
+
+[Some *link*](a.md) [Removed **link**](remove.md) ![Alt](img.png "Title") ![Removed](remove.png)
+
+Visit https://example.com and <a href="b.md">b</a> <a href="remove.md">removed</a> <img src="c.png" alt="C">
+`), os.ModePerm))
+	file, err := os.OpenFile(filepath.Join(tmpDir, "doc.md"), os.O_RDONLY, 0)
+	testutil.Ok(t, err)
+	defer file.Close()
+
+	m := &mockStructuredLinkTransformer{}
+	f := New(context.Background())
+	f.link = m
+
+	buf := bytes.Buffer{}
+	testutil.Ok(t, f.Format(file, &buf))
+	testutil.Equals(t, `# Doc
+
+[SOME LINK](a.md) Removed **link** ![Alt](img.png "Image: Alt") Removed
+
+Visit [Example](https://example.com) and <a href="b.md#anchor"> b </a> removed <img src="c.png" alt="C" title="Image: C">
+`, buf.String())
+	testutil.Equals(t, []LinkKind{
+		LinkKindLink, LinkKindLink, LinkKindImage, LinkKindImage,
+		LinkKindAutoLink, LinkKindHTMLAnchor, LinkKindHTMLAnchor, LinkKindHTMLImage,
+	}, m.kinds)
+}
+
+type mockLinesTransformer struct {
+	lines []string
+}
+
+func (m *mockLinesTransformer) TransformDestination(ctx SourceContext, destination []byte) ([]byte, error) {
+	m.lines = append(m.lines, string(destination)+":"+ctx.LineNumbers)
+	return destination, nil
+}
+
+func (m *mockLinesTransformer) TransformCodeBlock(ctx SourceContext, infoString []byte, code []byte) ([]byte, error) {
+	m.lines = append(m.lines, string(infoString)+":"+ctx.LineNumbers)
+	return code, nil
+}
+
+func (*mockLinesTransformer) Close(SourceContext) error { return nil }
+
+func TestFormat_FormatSingle_FrontMatterLineNumbers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-front-matter-lines")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	// Line numbers count all lines of front matter, not its keys.
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "doc.md"), []byte(`---
+title: Doc
+tags:
+  - a
+  - b
+---
+
+# Doc
+
+[A](a.md)
+
+`+"```go"+`
+package main
+`+"```"+`
+
+<a href="b.md">B</a> [A again](a.md)
+`), os.ModePerm))
+	file, err := os.OpenFile(filepath.Join(tmpDir, "doc.md"), os.O_RDONLY, 0)
+	testutil.Ok(t, err)
+	defer file.Close()
+
+	m := &mockLinesTransformer{}
+	f := New(context.Background(), WithLinkTransformer(m), WithCodeBlockTransformer(m))
+	testutil.Ok(t, f.Format(file, &bytes.Buffer{}))
+	testutil.Equals(t, []string{"a.md:10,16", "go:12", "b.md:16", "a.md:10,16"}, m.lines)
+}
+
+type mockHeadingTransformer struct {
+	numbers []int
+	lines   []string
+}
+
+func (m *mockHeadingTransformer) TransformHeading(ctx SourceContext, heading *Heading) error {
+	m.lines = append(m.lines, ctx.LineNumbers)
+	for len(m.numbers) < heading.Level {
+		m.numbers = append(m.numbers, 0)
+	}
+	m.numbers = m.numbers[:heading.Level]
+	m.numbers[heading.Level-1]++
+
+	var number []string
+	for _, n := range m.numbers {
+		number = append(number, strconv.Itoa(n))
+	}
+	heading.Text = []byte(strings.TrimSpace(strings.Join(number, ".") + " " + string(heading.Text)))
+	if len(heading.ID) == 0 {
+		heading.ID = []byte("h-" + strings.Join(number, "-"))
+	}
+	heading.Level++
+	return nil
+}
+
+func (*mockHeadingTransformer) Close(SourceContext) error { return nil }
+
+type mockTextTransformer struct {
+	lines []string
+}
+
+func (m *mockTextTransformer) TransformText(ctx SourceContext, text []byte) ([]byte, error) {
+	m.lines = append(m.lines, ctx.LineNumbers)
+	return bytes.ReplaceAll(text, []byte("k8s"), []byte("Kubernetes")), nil
+}
+
+func (*mockTextTransformer) Close(SourceContext) error { return nil }
+
+func TestFormat_FormatSingle_HeadingAndTextTransformers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-heading-text")
+	testutil.Ok(t, err)
+	t.Cleanup(func() { testutil.Ok(t, os.RemoveAll(tmpDir)) })
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(tmpDir, "doc.md"), []byte(`---
+title: k8s
+---
+
+# Running on k8s
+
+Deploy to k8s, see [k8s docs](https://k8s.io) and *k8s* guide, but not `+"`k8s`"+` code.
+
+## Setup {#custom-id}
+
+* k8s_cluster
+  on many k8s nodes
+
+`+"```"+`
+k8s
+`+"```"+`
+
+## Other k8s
+
+| k8s |
+|-----|
+| 1   |
+
+#
+
+##
+`), os.ModePerm))
+	file, err := os.OpenFile(filepath.Join(tmpDir, "doc.md"), os.O_RDONLY, 0)
+	testutil.Ok(t, err)
+	defer file.Close()
+
+	heading, text := &mockHeadingTransformer{}, &mockTextTransformer{}
+	f := New(context.Background(), WithHeadingTransformer(heading), WithTextTransformer(text))
+
+	buf := bytes.Buffer{}
+	testutil.Ok(t, f.Format(file, &buf))
+	testutil.Equals(t, `---
+title: k8s
+---
+
+## 1 Running on Kubernetes {#h-1}
+
+Deploy to Kubernetes, see [Kubernetes docs](https://k8s.io) and *Kubernetes* guide, but not `+"`k8s`"+` code.
+
+### 1.1 Setup {#custom-id}
+
+* Kubernetes_cluster on many Kubernetes nodes
+
+`+"```"+`
+k8s
+`+"```"+`
+
+### 1.2 Other Kubernetes {#h-1-2}
+
+| Kubernetes |
+|------------|
+| 1          |
+
+## 2 {#h-2}
+
+### 2.1 {#h-2-1}
+`, buf.String())
+	testutil.Equals(t, []string{"5", "9", "18", "", ""}, heading.lines)
+	testutil.Equals(t, []string{"5", "7", "7", "7", "7", "7", "7", "9", "9", "11", "12", "18", "20", "22"}, text.lines)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+// Package slug generates heading IDs (anchors) the same way as popular markdown renderers do, so links to headings
+// can be validated consistently with the place where markdown is published.
+package slug
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Style represents algorithm of generating heading IDs.
+type Style string
+
+const (
+	// GitHub generates IDs like GitHub does (github-slugger): lower case text with punctuation removed
+	// and each space replaced with '-'.
+	GitHub Style = "github"
+	// Hugo generates IDs like Hugo with Blackfriday does: lower case letters and numbers, with any other
+	// characters between them collapsed into single '-'. Custom IDs (`# Heading {#id}`) are respected.
+	Hugo Style = "hugo"
+	// Docusaurus generates IDs like Docusaurus does: the same as GitHub, but custom IDs (`# Heading {#id}`)
+	// are respected.
+	Docusaurus Style = "docusaurus"
+)
+
+// Styles contains all supported styles.
+var Styles = []Style{GitHub, Hugo, Docusaurus}
+
+var (
+	// '\p{L}\p{N}\p{M}' is the unicode equivalent of '\w', https://www.regular-expressions.info/unicode.html.
+	githubPunctuationRe = regexp.MustCompile(`[^\p{L}\p{N}\p{M}\p{Pc}\- ]`)
+	customIDRe          = regexp.MustCompile(`\s*\{#([^\s}]+)\}\s*$`)
+)
+
+// ID returns heading ID for given heading text in given style. It does not take into account other headings
+// in the same document, use Slugger for that.
+func (s Style) ID(heading string) string {
+	heading = strings.TrimSpace(heading)
+	switch s {
+	case Hugo:
+		if id, ok := customID(heading); ok {
+			return id
+		}
+		return blackfridayID(heading)
+	case Docusaurus:
+		if id, ok := customID(heading); ok {
+			return id
+		}
+		return githubID(heading)
+	default:
+		return githubID(heading)
+	}
+}
+
+func customID(heading string) (string, bool) {
+	m := customIDRe.FindStringSubmatch(heading)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+func githubID(heading string) string {
+	return strings.ReplaceAll(githubPunctuationRe.ReplaceAllString(strings.ToLower(heading), ""), " ", "-")
+}
+
+// blackfridayID is equivalent of blackfriday.SanitizedAnchorName.
+func blackfridayID(heading string) string {
+	var (
+		id         []rune
+		futureDash bool
+	)
+	for _, r := range heading {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			futureDash = true
+			continue
+		}
+		if futureDash && len(id) > 0 {
+			id = append(id, '-')
+		}
+		futureDash = false
+		id = append(id, unicode.ToLower(r))
+	}
+	return string(id)
+}
+
+// Slugger generates unique heading IDs within single document. Duplicated IDs get '-<number>' suffix
+// e.g for three "Example" headings IDs are "example", "example-1" and "example-2".
+// Slugger is not goroutine safe.
+type Slugger struct {
+	style       Style
+	occurrences map[string]int
+}
+
+// New returns new Slugger for given style.
+func New(style Style) *Slugger {
+	return &Slugger{style: style, occurrences: map[string]int{}}
+}
+
+// ID returns unique heading ID for given heading text.
+func (s *Slugger) ID(heading string) string {
+	id := s.style.ID(heading)
+	original := id
+	for {
+		if _, ok := s.occurrences[id]; !ok {
+			break
+		}
+		s.occurrences[original]++
+		id = original + "-" + strconv.Itoa(s.occurrences[original])
+	}
+	s.occurrences[id] = 0
+	return id
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package slug
+
+import (
+	"testing"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+)
+
+func TestSlugger_ID(t *testing.T) {
+	headings := []string{
+		"Expose UI on a sub-path",
+		"Run-time deduplication of HA groups",
+		"Twój wkład w dokumentację",
+		"`--code.format` flag & YAML_files",
+		"What's new?  (v0.2.1)",
+		"Example",
+		"Example",
+		"Example 1",
+		"Example",
+		"Custom ID {#my-id}",
+		"!!!",
+	}
+	for _, tcase := range []struct {
+		style    Style
+		expected []string
+	}{
+		{
+			style: GitHub,
+			expected: []string{
+				"expose-ui-on-a-sub-path",
+				"run-time-deduplication-of-ha-groups",
+				"twój-wkład-w-dokumentację",
+				"--codeformat-flag--yaml_files",
+				"whats-new--v021",
+				"example",
+				"example-1",
+				"example-1-1",
+				"example-2",
+				"custom-id-my-id",
+				"",
+			},
+		},
+		{
+			style: Hugo,
+			expected: []string{
+				"expose-ui-on-a-sub-path",
+				"run-time-deduplication-of-ha-groups",
+				"twój-wkład-w-dokumentację",
+				"code-format-flag-yaml-files",
+				"what-s-new-v0-2-1",
+				"example",
+				"example-1",
+				"example-1-1",
+				"example-2",
+				"my-id",
+				"",
+			},
+		},
+		{
+			style: Docusaurus,
+			expected: []string{
+				"expose-ui-on-a-sub-path",
+				"run-time-deduplication-of-ha-groups",
+				"twój-wkład-w-dokumentację",
+				"--codeformat-flag--yaml_files",
+				"whats-new--v021",
+				"example",
+				"example-1",
+				"example-1-1",
+				"example-2",
+				"my-id",
+				"",
+			},
+		},
+	} {
+		t.Run(string(tcase.style), func(t *testing.T) {
+			s := New(tcase.style)
+			var ids []string
+			for _, h := range headings {
+				ids = append(ids, s.ID(h))
+			}
+			testutil.Equals(t, tcase.expected, ids)
+		})
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package mdformatter
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Syntax commonly used on top of GFM and regions excluded from formatting, which are parsed and rendered back by
+// every Formatter, so formatting does not mangle them.
+var (
+	defaultExtensions = []goldmark.Extender{
+		extension.GFM,
+		extension.DefinitionList,
+		footnotes{},
+		mathBlocks{},
+		githubAlerts{},
+		formatOffRegions{},
+	}
+	defaultNodeRenderers = map[ast.NodeKind]NodeRenderer{
+		extast.KindFootnote:              renderFootnote,
+		extast.KindFootnoteLink:          renderFootnoteLink,
+		extast.KindDefinitionList:        renderDefinitionList,
+		extast.KindDefinitionTerm:        renderDefinitionTerm,
+		extast.KindDefinitionDescription: renderDefinitionDescription,
+		KindMathBlock:                    renderMathBlock,
+		KindFormatOffRegion:              renderFormatOffRegion,
+	}
+)
+
+// indent prefixes all non-empty lines of b, except the first one, with given indentation.
+func indent(b []byte, indentation string) []byte {
+	lines := bytes.Split(b, []byte("\n"))
+	for i := 1; i < len(lines); i++ {
+		if len(lines[i]) > 0 {
+			lines[i] = append([]byte(indentation), lines[i]...)
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// footnotes parses footnotes like extension.Footnote, but keeps definitions where they are, instead of moving them to
+// list at the end of document. Unreferenced definitions are kept too.
+type footnotes struct{}
+
+func (footnotes) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(footnoteBlockParser{BlockParser: extension.NewFootnoteBlockParser()}, 999)),
+		parser.WithInlineParsers(util.Prioritized(footnoteParser{}, 101)),
+	)
+}
+
+// footnoteDefsKey is a parser context key of footnoteDefs.
+var footnoteDefsKey = parser.NewContextKey()
+
+// footnoteRefAttr is an attribute of FootnoteLink with reference (label) of footnote it points to.
+var footnoteRefAttr = []byte("ref")
+
+type footnoteDefs struct {
+	byRef map[string]*extast.Footnote
+	count int
+}
+
+// footnoteBlockParser is a goldmark footnote block parser, which does not move closed footnotes to the list.
+type footnoteBlockParser struct {
+	parser.BlockParser
+}
+
+func (footnoteBlockParser) Close(node ast.Node, _ text.Reader, pc parser.Context) {
+	defs, ok := pc.Get(footnoteDefsKey).(*footnoteDefs)
+	if !ok {
+		defs = &footnoteDefs{byRef: map[string]*extast.Footnote{}}
+		pc.Set(footnoteDefsKey, defs)
+	}
+	f := node.(*extast.Footnote)
+	if _, ok := defs.byRef[string(f.Ref)]; !ok {
+		// Like in goldmark, the first definition wins.
+		defs.byRef[string(f.Ref)] = f
+	}
+}
+
+// footnoteParser parses footnote references, e.g `[^1]`, to definitions found by footnoteBlockParser.
+type footnoteParser struct{}
+
+func (footnoteParser) Trigger() []byte { return []byte{'!', '['} }
+
+func (footnoteParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	pos := 1
+	if len(line) > 0 && line[0] == '!' {
+		pos++
+	}
+	if pos+1 >= len(line) || line[pos] != '^' {
+		return nil
+	}
+	pos++
+	closure := util.FindClosure(line[pos:], '[', ']', false, false)
+	if closure < 0 {
+		return nil
+	}
+	defs, ok := pc.Get(footnoteDefsKey).(*footnoteDefs)
+	if !ok {
+		return nil
+	}
+	ref := block.Value(text.NewSegment(segment.Start+pos, segment.Start+pos+closure))
+	def, ok := defs.byRef[string(ref)]
+	if !ok {
+		return nil
+	}
+	block.Advance(pos + closure + 1)
+
+	if def.Index < 0 {
+		defs.count++
+		def.Index = defs.count
+	}
+	link := extast.NewFootnoteLink(def.Index)
+	link.SetAttribute(footnoteRefAttr, ref)
+	if line[0] == '!' {
+		parent.AppendChild(parent, ast.NewTextSegment(text.NewSegment(segment.Start, segment.Start+1)))
+	}
+	return link
+}
+
+func renderFootnote(_ []byte, n ast.Node, children [][]byte) ([]byte, error) {
+	b := bytes.Buffer{}
+	b.WriteString("[^")
+	b.Write(n.(*extast.Footnote).Ref)
+	b.WriteString("]:")
+	for i, c := range children {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString("\n\n    ")
+		}
+		b.Write(indent(bytes.TrimRight(c, "\n"), "    "))
+	}
+	return b.Bytes(), nil
+}
+
+func renderFootnoteLink(_ []byte, n ast.Node, _ [][]byte) ([]byte, error) {
+	ref, ok := n.Attribute(footnoteRefAttr)
+	if !ok {
+		return nil, errors.Errorf("no reference of footnote link with index %v", n.(*extast.FootnoteLink).Index)
+	}
+	return append(append([]byte("[^"), ref.([]byte)...), ']'), nil
+}
+
+func renderDefinitionList(_ []byte, n ast.Node, children [][]byte) ([]byte, error) {
+	b := bytes.Buffer{}
+	for c, i := n.FirstChild(), 0; c != nil; c, i = c.NextSibling(), i+1 {
+		if i > 0 {
+			b.WriteString("\n")
+			if c.Kind() == extast.KindDefinitionTerm && c.PreviousSibling().Kind() == extast.KindDefinitionDescription {
+				b.WriteString("\n")
+			}
+			if d, ok := c.(*extast.DefinitionDescription); ok && !d.IsTight {
+				b.WriteString("\n")
+			}
+		}
+		b.Write(children[i])
+	}
+	return b.Bytes(), nil
+}
+
+func renderDefinitionTerm(_ []byte, _ ast.Node, children [][]byte) ([]byte, error) {
+	return bytes.TrimRight(bytes.Join(children, nil), "\n"), nil
+}
+
+func renderDefinitionDescription(_ []byte, _ ast.Node, children [][]byte) ([]byte, error) {
+	b := bytes.Buffer{}
+	b.WriteString(":")
+	for i, c := range children {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString("\n\n  ")
+		}
+		b.Write(indent(bytes.TrimRight(c, "\n"), "  "))
+	}
+	return b.Bytes(), nil
+}
+
+// KindMathBlock is a NodeKind of the MathBlock node.
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// MathBlock represents block of math (e.g LaTeX) expressions delimited with `$$`. Its lines, including delimiters,
+// are kept as they are. Block without closing delimiter ends at the first empty line.
+type MathBlock struct {
+	ast.BaseBlock
+
+	closed bool
+}
+
+// Kind implements ast.Node.Kind.
+func (n *MathBlock) Kind() ast.NodeKind { return KindMathBlock }
+
+// IsRaw implements ast.Node.IsRaw.
+func (n *MathBlock) IsRaw() bool { return true }
+
+// Dump implements ast.Node.Dump.
+func (n *MathBlock) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+var mathDelimiter = []byte("$$")
+
+type mathBlocks struct{}
+
+func (mathBlocks) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 750)))
+}
+
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(_ ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	// Only lines with just the delimiter or whole block, e.g `$$ x^2 $$`, open math block.
+	l := util.TrimRightSpace(line[pos:])
+	single := len(l) >= 2*len(mathDelimiter) && bytes.HasPrefix(l, mathDelimiter) && bytes.HasSuffix(l, mathDelimiter)
+	if !single && !bytes.Equal(l, mathDelimiter) {
+		return nil, parser.NoChildren
+	}
+	node := &MathBlock{closed: single}
+	node.Lines().Append(text.NewSegment(segment.Start+pos-segment.Padding, segment.Stop))
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, _ parser.Context) parser.State {
+	n := node.(*MathBlock)
+	line, segment := reader.PeekLine()
+	// Block not closed before empty line ends there, so it does not swallow the rest of document.
+	if n.closed || util.IsBlank(line) {
+		return parser.Close
+	}
+	n.Lines().Append(segment)
+	newline := 0
+	if line[len(line)-1] == '\n' {
+		newline = 1
+	}
+	reader.Advance(segment.Len() - newline)
+	if bytes.HasSuffix(util.TrimRightSpace(line), mathDelimiter) {
+		n.closed = true
+		return parser.Close
+	}
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(ast.Node, text.Reader, parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool { return false }
+
+func (mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+func renderMathBlock(source []byte, n ast.Node, _ [][]byte) ([]byte, error) {
+	b := bytes.Buffer{}
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		b.Write(line.Value(source))
+	}
+	return bytes.TrimRight(b.Bytes(), "\n"), nil
+}
+
+// alertRe matches first line of GitHub alert blockquote, e.g `> [!NOTE]`.
+var alertRe = regexp.MustCompile(`(?i)^\s*\[!(NOTE|TIP|IMPORTANT|WARNING|CAUTION)\]\s*$`)
+
+// githubAlerts keeps alert type of GitHub alert blockquotes in its own line, as required by GitHub, instead of
+// joining it with the rest of paragraph.
+type githubAlerts struct{}
+
+func (githubAlerts) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(githubAlerts{}, 100)))
+}
+
+func (githubAlerts) Transform(doc *ast.Document, reader text.Reader, _ parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Kind() != ast.KindBlockquote {
+			return ast.WalkContinue, nil
+		}
+		p, ok := n.FirstChild().(*ast.Paragraph)
+		if !ok || p.Lines().Len() < 2 {
+			return ast.WalkContinue, nil
+		}
+		if first := p.Lines().At(0); !alertRe.Match(first.Value(reader.Source())) {
+			return ast.WalkContinue, nil
+		}
+		for c := p.FirstChild(); c != nil; c = c.NextSibling() {
+			if t, ok := c.(*ast.Text); ok && t.SoftLineBreak() {
+				// Text.SetSoftLineBreak(false) does not unset soft line break, so replace text instead.
+				hard := ast.NewTextSegment(t.Segment)
+				hard.SetRaw(t.IsRaw())
+				hard.SetHardLineBreak(true)
+				p.ReplaceChild(p, t, hard)
+				break
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Bartłomiej Płotka @bwplotka
+// Licensed under the Apache License 2.0.
+
+package mdformatter
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/efficientgo/tools/core/pkg/testutil"
+)
+
+func TestFormat_FormatSingle_Syntax(t *testing.T) {
+	exp, err := ioutil.ReadFile("testdata/syntax_formatted.md")
+	testutil.Ok(t, err)
+
+	for _, tcase := range []string{"testdata/syntax_not_formatted.md", "testdata/syntax_formatted.md"} {
+		t.Run(tcase, func(t *testing.T) {
+			file, err := os.OpenFile(tcase, os.O_RDONLY, 0)
+			testutil.Ok(t, err)
+			defer file.Close()
+
+			buf := bytes.Buffer{}
+			testutil.Ok(t, New(context.Background()).Format(file, &buf))
+			testutil.Equals(t, string(exp), buf.String())
+		})
+	}
+}
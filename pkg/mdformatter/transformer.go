@@ -10,6 +10,7 @@ import (
 	"strconv"
 
 	"github.com/efficientgo/tools/core/pkg/merrors"
+	"github.com/pkg/errors"
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/renderer"
 	"github.com/yuin/goldmark/text"
@@ -31,19 +32,39 @@ type transformer struct {
 
 	sourceCtx SourceContext
 
-	link           LinkTransformer
-	cb             CodeBlockTransformer
-	frontMatterLen int
+	link    LinkTransformer
+	cb      CodeBlockTransformer
+	heading HeadingTransformer
+	text    TextTransformer
+	// formatOffLinks makes link transformer see links in FormatOffRegion nodes.
+	formatOffLinks bool
+	// frontMatterLines is a number of lines before source, which is file content without front matter.
+	frontMatterLines int
 }
 
 func (t *transformer) Render(w io.Writer, source []byte, node ast.Node) error {
-	if t.link == nil && t.cb == nil {
+	if t.link == nil && t.cb == nil && t.heading == nil && t.text == nil {
 		return t.wrapped.Render(w, source, node)
 	}
 
-	if err := ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
-		var err error
+	// removedAnchors is a number of removed HTML <a> tags, which end tags have to be removed too. Inline HTML tags are
+	// separate nodes, so it's counted across them.
+	removedAnchors := 0
+	// Transformers other than link one are disabled in FormatOffRegion.
+	cbTr, headingTr, textTr := t.cb, t.heading, t.text
+	if err := ast.Walk(node, func(n ast.Node, entering bool) (_ ast.WalkStatus, err error) {
 		switch typedNode := n.(type) {
+		case *FormatOffRegion:
+			if !t.formatOffLinks || t.link == nil {
+				return ast.WalkSkipChildren, nil
+			}
+			// Region is kept as it is, so only links are transformed there, e.g to be validated.
+			if entering {
+				t.cb, t.heading, t.text = nil, nil, nil
+			} else {
+				t.cb, t.heading, t.text = cbTr, headingTr, textTr
+			}
+			return ast.WalkContinue, nil
 		case *ast.HTMLBlock, *ast.RawHTML:
 			if !entering || t.link == nil {
 				return ast.WalkSkipChildren, nil
@@ -72,33 +93,49 @@ func (t *transformer) Render(w io.Writer, source []byte, node ast.Node) error {
 			z := html.NewTokenizer(&b)
 			for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
 				token := z.Token()
-				switch token.Data {
-				case "img":
-					for i := range token.Attr {
-						if token.Attr[i].Key != "src" {
-							continue
-						}
-						t.sourceCtx.LineNumbers = getLinkLines(source, []byte(token.Attr[i].Val), t.frontMatterLen)
-						dest, err := t.link.TransformDestination(t.sourceCtx, []byte(token.Attr[i].Val))
-						if err != nil {
-							return ast.WalkStop, err
-						}
-						token.Attr[i].Val = string(dest)
+				switch {
+				case token.Data == "img" && tt != html.EndTagToken:
+					src := htmlAttr(&token, "src")
+					if src == nil {
 						break
 					}
-				case "a":
-					for i := range token.Attr {
-						if token.Attr[i].Key != "href" {
-							continue
-						}
-						t.sourceCtx.LineNumbers = getLinkLines(source, []byte(token.Attr[i].Val), t.frontMatterLen)
-						dest, err := t.link.TransformDestination(t.sourceCtx, []byte(token.Attr[i].Val))
-						if err != nil {
-							return ast.WalkStop, err
-						}
-						token.Attr[i].Val = string(dest)
+					alt := htmlAttr(&token, "alt")
+					link := &Link{Kind: LinkKindHTMLImage, Destination: []byte(*src), Title: htmlAttrValue(&token, "title")}
+					if alt != nil {
+						link.Text = []byte(*alt)
+					}
+					if err := t.transformLink(source, link); err != nil {
+						return ast.WalkStop, err
+					}
+					if link.Remove {
+						out += string(link.Text)
+						continue
+					}
+					*src = string(link.Destination)
+					if alt != nil {
+						*alt = string(link.Text)
+					} else if len(link.Text) > 0 {
+						token.Attr = append(token.Attr, html.Attribute{Key: "alt", Val: string(link.Text)})
+					}
+					setHTMLAttr(&token, "title", link.Title)
+				case token.Data == "a" && tt == html.StartTagToken:
+					href := htmlAttr(&token, "href")
+					if href == nil {
 						break
 					}
+					link := &Link{Kind: LinkKindHTMLAnchor, Destination: []byte(*href), Title: htmlAttrValue(&token, "title")}
+					if err := t.transformLink(source, link); err != nil {
+						return ast.WalkStop, err
+					}
+					if link.Remove {
+						removedAnchors++
+						continue
+					}
+					*href = string(link.Destination)
+					setHTMLAttr(&token, "title", link.Title)
+				case token.Data == "a" && tt == html.EndTagToken && removedAnchors > 0:
+					removedAnchors--
+					continue
 				}
 				out += token.String()
 			}
@@ -106,52 +143,92 @@ func (t *transformer) Render(w io.Writer, source []byte, node ast.Node) error {
 				return ast.WalkStop, err
 			}
 
-			repl := ast.NewString([]byte("\n" + out + "\n"))
+			if out != "" {
+				out = "\n" + out + "\n"
+			}
+			repl := ast.NewString([]byte(out))
 			repl.SetParent(n.Parent())
 			repl.SetPreviousSibling(n.PreviousSibling())
 			repl.SetNextSibling(n.NextSibling())
 			n.Parent().ReplaceChild(n.Parent(), n, repl)
 			n.SetNextSibling(repl.NextSibling()) // Make sure our loop can continue.
 		case *ast.Link:
-			if !entering || t.link == nil {
+			if !entering {
 				return ast.WalkSkipChildren, nil
 			}
-			t.sourceCtx.LineNumbers = getLinkLines(source, typedNode.Destination, t.frontMatterLen)
-			typedNode.Destination, err = t.link.TransformDestination(t.sourceCtx, typedNode.Destination)
-			if err != nil {
+			if t.link == nil {
+				return ast.WalkContinue, nil
+			}
+			link := &Link{Kind: LinkKindLink, Destination: typedNode.Destination, Title: typedNode.Title, Text: n.Text(source)}
+			if source, err = t.transformLinkNode(n, source, link); err != nil {
 				return ast.WalkStop, err
 			}
+			typedNode.Destination, typedNode.Title = link.Destination, link.Title
 		case *ast.AutoLink:
 			if !entering || t.link == nil || typedNode.AutoLinkType != ast.AutoLinkURL {
 				return ast.WalkSkipChildren, nil
 			}
-			t.sourceCtx.LineNumbers = getLinkLines(source, typedNode.URL(source), t.frontMatterLen)
-			dest, err := t.link.TransformDestination(t.sourceCtx, typedNode.URL(source))
-			if err != nil {
+			url := typedNode.URL(source)
+			link := &Link{Kind: LinkKindAutoLink, Destination: url, Text: typedNode.Label(source)}
+			if err := t.transformLink(source, link); err != nil {
 				return ast.WalkStop, err
 			}
-			if bytes.Equal(dest, typedNode.URL(source)) {
+			var repl ast.Node
+			switch {
+			case link.Remove:
+				repl = ast.NewString(link.Text)
+			case !bytes.Equal(link.Text, typedNode.Label(source)) || len(link.Title) > 0:
+				l := ast.NewLink()
+				l.Destination, l.Title = link.Destination, link.Title
+				l.AppendChild(l, ast.NewString(link.Text))
+				repl = l
+			case !bytes.Equal(link.Destination, url):
+				repl = ast.NewString(link.Destination)
+			default:
 				return ast.WalkSkipChildren, nil
 			}
-			repl := ast.NewString(dest)
 			repl.SetParent(n)
 			n.Parent().ReplaceChild(n.Parent(), n, repl)
 			n.SetNextSibling(repl.NextSibling()) // Make sure our loop can continue.
-
 		case *ast.Image:
-			if !entering || t.link == nil {
+			if !entering {
 				return ast.WalkSkipChildren, nil
 			}
-			t.sourceCtx.LineNumbers = getLinkLines(source, typedNode.Destination, t.frontMatterLen)
-			typedNode.Destination, err = t.link.TransformDestination(t.sourceCtx, typedNode.Destination)
-			if err != nil {
+			if t.link == nil {
+				return ast.WalkContinue, nil
+			}
+			link := &Link{Kind: LinkKindImage, Destination: typedNode.Destination, Title: typedNode.Title, Text: n.Text(source)}
+			if source, err = t.transformLinkNode(n, source, link); err != nil {
+				return ast.WalkStop, err
+			}
+			typedNode.Destination, typedNode.Title = link.Destination, link.Title
+		case *ast.Heading:
+			if !entering || t.heading == nil {
+				return ast.WalkContinue, nil
+			}
+			// Heading transformer gets already transformed text.
+			if source, err = t.transformTexts(n, source); err != nil {
 				return ast.WalkStop, err
 			}
+			if err := t.transformHeading(typedNode, source); err != nil {
+				return ast.WalkStop, err
+			}
+		case *ast.Text:
+			if !entering || t.text == nil {
+				return ast.WalkSkipChildren, nil
+			}
+			if source, err = t.transformText(typedNode, source); err != nil {
+				return ast.WalkStop, err
+			}
+		case *ast.CodeSpan:
+			// Code is not a prose.
+			return ast.WalkSkipChildren, nil
 		case *ast.FencedCodeBlock:
 			if !entering || t.cb == nil || typedNode.Info == nil {
 				return ast.WalkSkipChildren, nil
 			}
-			blockContent, err := t.cb.TransformCodeBlock(t.sourceCtx, typedNode.Info.Text(source), typedNode.Text(source))
+			t.sourceCtx.LineNumbers = getCodeBlockLine(source, typedNode, t.frontMatterLines)
+			blockContent, err := t.cb.TransformCodeBlock(t.sourceCtx, typedNode.Info.Text(source), linesContent(typedNode, source))
 			if err != nil {
 				return ast.WalkStop, err
 			}
@@ -169,6 +246,168 @@ func (t *transformer) Render(w io.Writer, source []byte, node ast.Node) error {
 	return t.wrapped.Render(w, source, node)
 }
 
+// transformHeading transforms given heading with heading transformer.
+func (t *transformer) transformHeading(n *ast.Heading, source []byte) error {
+	h := &Heading{Level: n.Level, Text: n.Text(source)}
+	if id, ok := n.AttributeString("id"); ok {
+		h.ID, _ = id.([]byte)
+	}
+	oldText, oldID := h.Text, h.ID
+	// Empty heading (e.g `#`) has no lines, parser does not record its position.
+	t.sourceCtx.LineNumbers = ""
+	if n.Lines().Len() > 0 {
+		t.sourceCtx.LineNumbers = strconv.Itoa(bytes.Count(source[:n.Lines().At(0).Start], []byte("\n")) + 1 + t.frontMatterLines)
+	}
+	if err := t.heading.TransformHeading(t.sourceCtx, h); err != nil {
+		return err
+	}
+	if h.Level < 1 || h.Level > 6 {
+		pos := t.sourceCtx.Filepath
+		if t.sourceCtx.LineNumbers != "" {
+			pos += ":" + t.sourceCtx.LineNumbers
+		}
+		return errors.Errorf("%v: heading level %v out of range, expected from 1 to 6", pos, h.Level)
+	}
+	n.Level = h.Level
+	if !bytes.Equal(h.Text, oldText) {
+		n.RemoveChildren(n)
+		n.AppendChild(n, ast.NewString(h.Text))
+	}
+	if !bytes.Equal(h.ID, oldID) {
+		attrs := n.Attributes()
+		n.RemoveAttributes()
+		for _, a := range attrs {
+			if string(a.Name) != "id" {
+				n.SetAttribute(a.Name, a.Value)
+			}
+		}
+		if len(h.ID) > 0 {
+			n.SetAttributeString("id", h.ID)
+		}
+	}
+	return nil
+}
+
+// transformText transforms text of given node and of following text nodes it continues with, as goldmark splits
+// text into many nodes. Transformed text is appended to returned source and merged into the given node.
+func (t *transformer) transformText(n *ast.Text, source []byte) ([]byte, error) {
+	last := n
+	for !last.SoftLineBreak() && !last.HardLineBreak() {
+		next, ok := last.NextSibling().(*ast.Text)
+		if !ok || next.Segment.Start != last.Segment.Stop {
+			break
+		}
+		last = next
+	}
+	content := source[n.Segment.Start:last.Segment.Stop]
+
+	t.sourceCtx.LineNumbers = strconv.Itoa(bytes.Count(source[:n.Segment.Start], []byte("\n")) + 1 + t.frontMatterLines)
+	newContent, err := t.text.TransformText(t.sourceCtx, content)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(newContent, content) {
+		return source, nil
+	}
+
+	for merged := ast.Node(n); merged != last; {
+		merged = n.NextSibling()
+		n.Parent().RemoveChild(n.Parent(), merged)
+	}
+	n.SetSoftLineBreak(last.SoftLineBreak())
+	n.SetHardLineBreak(last.HardLineBreak())
+	n.Segment = text.NewSegment(len(source), len(source)+len(newContent))
+	return append(source, newContent...), nil
+}
+
+// transformTexts transforms all texts in given node.
+func (t *transformer) transformTexts(n ast.Node, source []byte) (_ []byte, err error) {
+	if t.text == nil {
+		return source, nil
+	}
+	err = ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch typedNode := n.(type) {
+		case *ast.Text:
+			if entering {
+				if source, err = t.transformText(typedNode, source); err != nil {
+					return ast.WalkStop, err
+				}
+			}
+		case *ast.CodeSpan:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return source, err
+}
+
+// transformLink transforms given link found in source with link transformer.
+func (t *transformer) transformLink(source []byte, link *Link) error {
+	t.sourceCtx.LineNumbers = getLinkLines(source, link.Destination, t.frontMatterLines)
+	return TransformLink(t.sourceCtx, t.link, link)
+}
+
+// transformLinkNode transforms given link of link or image node and texts in it. Text of node is replaced if
+// transformer changed it and node is replaced with its content if transformer removed the link.
+func (t *transformer) transformLinkNode(n ast.Node, source []byte, link *Link) (_ []byte, err error) {
+	if err := t.transformLink(source, link); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(link.Text, n.Text(source)) {
+		n.RemoveChildren(n)
+		n.AppendChild(n, ast.NewString(link.Text))
+	}
+	if source, err = t.transformTexts(n, source); err != nil {
+		return nil, err
+	}
+	if link.Remove {
+		unwrapNode(n)
+	}
+	return source, nil
+}
+
+// unwrapNode replaces given node with its children.
+func unwrapNode(n ast.Node) {
+	parent, next := n.Parent(), n.NextSibling()
+	var children []ast.Node
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		children = append(children, c)
+	}
+	for _, c := range children {
+		parent.InsertBefore(parent, n, c)
+	}
+	parent.RemoveChild(parent, n)
+	n.SetNextSibling(next) // Make sure our loop can continue.
+}
+
+// htmlAttr returns pointer to value of given attribute of HTML token or nil if there is no such attribute.
+func htmlAttr(token *html.Token, key string) *string {
+	for i := range token.Attr {
+		if token.Attr[i].Key == key {
+			return &token.Attr[i].Val
+		}
+	}
+	return nil
+}
+
+func htmlAttrValue(token *html.Token, key string) []byte {
+	if v := htmlAttr(token, key); v != nil {
+		return []byte(*v)
+	}
+	return nil
+}
+
+// setHTMLAttr sets given attribute of HTML token, if value is not empty or attribute is already present.
+func setHTMLAttr(token *html.Token, key string, value []byte) {
+	if v := htmlAttr(token, key); v != nil {
+		*v = string(value)
+		return
+	}
+	if len(value) > 0 {
+		token.Attr = append(token.Attr, html.Attribute{Key: key, Val: string(value)})
+	}
+}
+
 func (t *transformer) Close(ctx SourceContext) error {
 	errs := merrors.New()
 	if t.link != nil {
@@ -177,9 +416,25 @@ func (t *transformer) Close(ctx SourceContext) error {
 	if t.cb != nil {
 		errs.Add(t.cb.Close(ctx))
 	}
+	if t.heading != nil {
+		errs.Add(t.heading.Close(ctx))
+	}
+	if t.text != nil {
+		errs.Add(t.text.Close(ctx))
+	}
 	return errs.Err()
 }
 
+// linesContent returns raw content of given block e.g code of code block.
+func linesContent(n ast.Node, source []byte) []byte {
+	b := bytes.Buffer{}
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		_, _ = b.Write(line.Value(source))
+	}
+	return b.Bytes()
+}
+
 func replaceContent(b *ast.BaseBlock, lastSegmentStop int, content []byte) {
 	s := text.NewSegments()
 	// NOTE(bwplotka): This feels like hack, because we pack all lines in single line. But it works (:
@@ -187,23 +442,24 @@ func replaceContent(b *ast.BaseBlock, lastSegmentStop int, content []byte) {
 	b.SetLines(s)
 }
 
+// getCodeBlockLine returns line number in source where opening fence of given code block is present.
+func getCodeBlockLine(source []byte, n *ast.FencedCodeBlock, fmLines int) string {
+	return strconv.Itoa(bytes.Count(source[:n.Info.Segment.Start], []byte("\n")) + 1 + fmLines)
+}
+
 // getLinkLines returns line numbers in source where link is present.
-func getLinkLines(source []byte, link []byte, lenfm int) string {
+func getLinkLines(source []byte, link []byte, fmLines int) string {
 	var targetLines string
 	sourceLines := bytes.Split(source, []byte("\n"))
-	// frontMatter is present so would need to account for `---` lines.
-	if lenfm > 0 {
-		lenfm += 2
-	}
 	// Using regex, as two links may have same host but diff params. Same in case of local links.
 	linkRe := regexp.MustCompile(`(^|[^/\-~&=#?@%a-zA-Z0-9])` + string(link) + `($|[^/\-~&=#?@%a-zA-Z0-9])`)
 	for i, line := range sourceLines {
 		if linkRe.Match(line) {
 			// Easier to just return int slice, but then cannot use it in futureKey.
 			// https://golang.org/ref/spec#Map_types.
-			add := strconv.Itoa(i + 1 + lenfm)
+			add := strconv.Itoa(i + 1 + fmLines)
 			if targetLines != "" {
-				add = "," + strconv.Itoa(i+1+lenfm)
+				add = "," + strconv.Itoa(i+1+fmLines)
 			}
 			targetLines += add
 		}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/bwplotka/mdox/pkg/mdformatter/linktransformer"
 	"github.com/gobwas/glob"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
@@ -47,6 +48,10 @@ type Config struct {
 
 	// LocalLinksStyle sets linking style to be applied. If empty, we assume default style.
 	LocalLinksStyle LocalLinksStyle `yaml:"localLinksStyle"`
+
+	// LinkRewrites are regex rules rewriting link destinations in all transformed files, applied after local links
+	// are adjusted to new file locations. File globs of rules are matched against paths relative to output directory.
+	LinkRewrites []linktransformer.RewriteRule `yaml:"linkRewrites"`
 }
 
 type TransformationConfig struct {
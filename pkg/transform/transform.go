@@ -14,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/bwplotka/mdox/pkg/mdformatter"
+	"github.com/bwplotka/mdox/pkg/mdformatter/linktransformer"
 	"github.com/efficientgo/tools/core/pkg/errcapture"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -55,6 +56,14 @@ func Dir(ctx context.Context, logger log.Logger, config []byte) error {
 		return err
 	}
 
+	var rewriter mdformatter.LinkTransformer
+	if len(c.LinkRewrites) > 0 {
+		rewriter, err = linktransformer.NewRewriterForRules(logger, c.OutputDir, c.LinkRewrites)
+		if err != nil {
+			return errors.Wrap(err, "linkRewrites")
+		}
+	}
+
 	if err := prepOutputDir(c.OutputDir, c.GitIgnored); err != nil {
 		return err
 	}
@@ -101,7 +110,11 @@ func Dir(ctx context.Context, logger log.Logger, config []byte) error {
 	}
 
 	// Once we did all the changes, change links.
-	return mdformatter.Format(ctx, logger, tr.filesToLinkAdjust, mdformatter.WithLinkTransformer(tr.linkTransformer))
+	var linkTr mdformatter.LinkTransformer = tr.linkTransformer
+	if rewriter != nil {
+		linkTr = linktransformer.NewChain(tr.linkTransformer, rewriter)
+	}
+	return mdformatter.Format(ctx, logger, tr.filesToLinkAdjust, mdformatter.WithLinkTransformer(linkTr))
 }
 
 type transformer struct {
@@ -47,6 +47,12 @@ func TestTransform(t *testing.T) {
 		assertDirContent(t, filepath.Join(testData, "expected", "test3"), filepath.Join(tmpDir, "test3"))
 
 	})
+	t.Run("mdox4.yaml", func(t *testing.T) {
+		mdox4, err := ioutil.ReadFile(filepath.Join(testData, "mdox4.yaml"))
+		testutil.Ok(t, err)
+		testutil.Ok(t, transform.Dir(context.Background(), logger, mdox4))
+		assertDirContent(t, filepath.Join(testData, "expected", "test4"), filepath.Join(tmpDir, "test4"))
+	})
 }
 
 func assertDirContent(t *testing.T, expectedDir string, gotDir string) {